@@ -11,8 +11,9 @@ import (
 
 	_ "github.com/docker/buildx/driver/docker"
 	_ "github.com/docker/buildx/driver/docker-container"
-	// _ "github.com/docker/buildx/driver/kubernetes"
-	// _ "github.com/robertgzr/asm/driver/containerd"
+	_ "github.com/docker/buildx/driver/kubernetes"
+	_ "github.com/robertgzr/asm/driver/containerd"
+	_ "github.com/robertgzr/asm/driver/remote"
 )
 
 func Assemble(ctx context.Context, dis []build.DriverInfo, targets map[string]*bake.Target, inp *bake.Input, printer *progress.Printer) error {
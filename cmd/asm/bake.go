@@ -4,12 +4,20 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"text/tabwriter"
 
 	"github.com/containerd/containerd/platforms"
 	"github.com/docker/buildx/bake"
+	"github.com/docker/buildx/build"
 	"github.com/docker/buildx/util/progress"
 	"github.com/docker/buildx/util/tracing"
+	"github.com/moby/buildkit/client"
 	"github.com/sirupsen/logrus"
 	cli "github.com/urfave/cli/v2"
 
@@ -22,6 +30,7 @@ var bakeCommand = &cli.Command{
 	Aliases:     []string{"f"},
 	Usage:       "bake [TARGET...]",
 	Description: "build from a file",
+	Before:      loadConfig,
 	Flags: []cli.Flag{
 		&cli.StringSliceFlag{
 			Name:    "file",
@@ -42,8 +51,20 @@ var bakeCommand = &cli.Command{
 			Usage: "set type of progress output (auto, plain, tty)",
 		},
 		&cli.StringSliceFlag{
-			Name:  "nodes",
-			Usage: "overwrite the build nodes",
+			Name:    "nodes",
+			Usage:   "select the build nodes by name or label (eg: location=office,!slow)",
+			EnvVars: []string{"ASM_NODES"},
+		},
+		&cli.StringFlag{
+			Name:    "nodes-required",
+			Usage:   "nodes that have to connect for the build to start (all, any or a number)",
+			Value:   "any",
+			EnvVars: []string{"ASM_NODES_REQUIRED"},
+		},
+		&cli.DurationFlag{
+			Name:    "platforms-cache",
+			Usage:   "reuse the platforms detected for nodes without platforms for this long, 0 disables the cache",
+			EnvVars: []string{"ASM_PLATFORMS_CACHE"},
 		},
 	},
 	Action: func(cx *cli.Context) (err error) {
@@ -52,24 +73,25 @@ var bakeCommand = &cli.Command{
 			return fmt.Errorf("missing node configuration")
 		}
 
-		logrus.Debugf("node configuration: %+v", cfg)
-
-		if nodes := cx.StringSlice("nodes"); len(nodes) != 0 {
-			var filtered config.NodeGroup
-			for _, n := range cfg.Nodes {
-				for _, name := range nodes {
-					if n.Name == name {
-						filtered.Nodes = append(filtered.Nodes, n)
-					}
-				}
-			}
-			if len(filtered.Nodes) == 0 {
+		selector, err := config.ParseSelector(cx.StringSlice("nodes"))
+		if err != nil {
+			return err
+		}
+		required, err := asm.ParseNodesRequired(cx.String("nodes-required"))
+		if err != nil {
+			return err
+		}
+		if !selector.Empty() {
+			cfg = cfg.Filter(selector)
+			if len(cfg.Nodes) == 0 {
 				return fmt.Errorf("no nodes left")
 			}
-			cfg = filtered
 		}
 
-		ctx, cancel := context.WithCancel(context.Background())
+		logrus.Debugf("node configuration: %+v", cfg)
+
+		// interrupting cancels connecting to the nodes and the build
+		ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 		defer cancel()
 
 		ctx, end, err := tracing.TraceCurrentCommand(ctx, "bake")
@@ -80,6 +102,40 @@ var bakeCommand = &cli.Command{
 			end(err)
 		}()
 
+		targets := []string{"default"}
+		if cx.Args().Present() {
+			targets = cx.Args().Slice()
+		}
+
+		contextPathHash, _ := os.Getwd()
+		allDis, err := asm.DriversForNodeGroup(ctx, &cfg, contextPathHash)
+		if err != nil {
+			return err
+		}
+		if !cx.Bool("print") {
+			var cache *asm.PlatformCache
+			if ttl := cx.Duration("platforms-cache"); ttl > 0 {
+				if cache, err = asm.OpenPlatformCache(ttl); err != nil {
+					return err
+				}
+			}
+			// the detection output has to end before the summary is printed
+			detectPrinter := &lazyPrinter{mode: cx.String("progress")}
+			err := asm.DetectPlatforms(ctx, cfg, allDis, detectPrinter, cache)
+			if err1 := detectPrinter.Wait(); err == nil {
+				err = err1
+			}
+			if err != nil {
+				return err
+			}
+		}
+		printNodeSummary(cx.App.ErrWriter, cfg, allDis)
+		dis, err := asm.ConnectedDrivers(allDis, required)
+		if err != nil {
+			return err
+		}
+		logrus.Debugf("resolved drivers: %+v", dis)
+
 		ctx2, cancelPrinter := context.WithCancel(context.TODO())
 		defer cancelPrinter()
 		printer := progress.NewPrinter(ctx2, os.Stderr, cx.String("progress"))
@@ -92,18 +148,6 @@ var bakeCommand = &cli.Command{
 			}
 		}()
 
-		targets := []string{"default"}
-		if cx.Args().Present() {
-			targets = cx.Args().Slice()
-		}
-
-		contextPathHash, _ := os.Getwd()
-		dis, err := asm.DriversForNodeGroup(ctx, &cfg, contextPathHash)
-		if err != nil {
-			return err
-		}
-		logrus.Debugf("resolved drivers: %+v", dis)
-
 		var (
 			url      string
 			defaults = map[string]string{
@@ -165,9 +209,59 @@ var bakeCommand = &cli.Command{
 			return nil
 		}
 
+		if err := asm.CheckPlatforms(allDis, m); err != nil {
+			return err
+		}
+
 		if err := asm.Assemble(ctx, dis, m, inp, printer); err != nil {
 			return fmt.Errorf("assembly failed: %w", err)
 		}
 		return nil
 	},
 }
+
+// lazyPrinter starts a progress printer on the first write, so nothing is
+// printed if nothing is written.
+type lazyPrinter struct {
+	mode string
+
+	once    sync.Once
+	printer *progress.Printer
+	cancel  context.CancelFunc
+}
+
+func (p *lazyPrinter) Write(s *client.SolveStatus) {
+	p.once.Do(func() {
+		var ctx context.Context
+		ctx, p.cancel = context.WithCancel(context.TODO())
+		p.printer = progress.NewPrinter(ctx, os.Stderr, p.mode)
+	})
+	p.printer.Write(s)
+}
+
+// Wait waits for the printer to finish, if it was started.
+func (p *lazyPrinter) Wait() error {
+	if p.printer == nil {
+		return nil
+	}
+	defer p.cancel()
+	return p.printer.Wait()
+}
+
+// printNodeSummary prints a table of the nodes of ng and whether they
+// connected, dis holds the drivers of the nodes in the same order.
+func printNodeSummary(w io.Writer, ng config.NodeGroup, dis []build.DriverInfo) {
+	tw := tabwriter.NewWriter(w, 0, 4, 4, ' ', tabwriter.TabIndent)
+	defer tw.Flush()
+
+	fmt.Fprintln(tw, "NODE\tDRIVER\tENDPOINT\tPLATFORMS\tSTATUS")
+	for i, n := range ng.Nodes {
+		status := "connected"
+		if err := dis[i].Err; err != nil {
+			status = "failed: " + strings.ReplaceAll(err.Error(), "\n", " ")
+		} else if dis[i].Driver == nil {
+			status = "failed"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", n.Name, n.Driver, n.Endpoint, formatPlatformArray(dis[i].Platform), status)
+	}
+}
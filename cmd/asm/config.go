@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+	cli "github.com/urfave/cli/v2"
+
+	"github.com/robertgzr/asm/config"
+)
+
+var configCommand = &cli.Command{
+	Name:  "config",
+	Usage: "manage the node configuration",
+	Action: func(cx *cli.Context) error {
+		return cli.ShowCommandHelp(cx, cx.Command.Name)
+	},
+	Subcommands: []*cli.Command{
+		validateConfigCommand,
+		migrateConfigCommand,
+		whichConfigCommand,
+	},
+}
+
+var validateConfigCommand = &cli.Command{
+	Name:  "validate",
+	Usage: "check the node configuration for errors",
+	Action: func(cx *cli.Context) error {
+		c, err := config.Load(configFiles(cx), cx.String("profile"))
+		var verrs config.ValidationErrors
+		if err == nil || errors.As(err, &verrs) {
+			// ValidateAll reports everything Validate does, parse errors
+			// leave nothing to validate and are kept
+			if verr := c.ValidateAll(); verr != nil || err == nil {
+				err = verr
+			}
+		}
+		if err := printValidationErrors(cx, err); err != nil {
+			return err
+		}
+		fmt.Fprintln(cx.App.Writer, "configuration is valid")
+		return nil
+	},
+}
+
+// printValidationErrors prints each problem of a validation error on its own
+// line and returns a summary, other errors are returned as they are.
+func printValidationErrors(cx *cli.Context, err error) error {
+	var verrs config.ValidationErrors
+	if errors.As(err, &verrs) {
+		for _, verr := range verrs {
+			fmt.Fprintln(cx.App.ErrWriter, verr)
+		}
+		return fmt.Errorf("%d problem(s) found", len(verrs))
+	}
+	return err
+}
+
+var migrateConfigCommand = &cli.Command{
+	Name:      "migrate",
+	Usage:     "rewrite config files in the newest schema version",
+	ArgsUsage: "[FILE...]",
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:  "dry-run",
+			Usage: "print the migrated files instead of writing them",
+		},
+	},
+	Action: func(cx *cli.Context) error {
+		fns := cx.Args().Slice()
+		if len(fns) == 0 {
+			var err error
+			if fns, err = config.Files(configFiles(cx)); err != nil {
+				return err
+			}
+		}
+
+		for _, fn := range fns {
+			if filepath.Ext(fn) == ".hcl" {
+				continue
+			}
+			b, from, err := config.Migrate(fn)
+			if err != nil {
+				return err
+			}
+			if cx.Bool("dry-run") {
+				fmt.Fprintf(cx.App.Writer, "# %s\n%s", fn, b)
+				continue
+			}
+			if from == config.Version {
+				fmt.Fprintf(cx.App.Writer, "%s: already at version %d\n", fn, from)
+				continue
+			}
+			fi, err := os.Stat(fn)
+			if err != nil {
+				return err
+			}
+			if err := ioutil.WriteFile(fn, b, fi.Mode()); err != nil {
+				return err
+			}
+			fmt.Fprintf(cx.App.Writer, "%s: migrated from version %d to %d\n", fn, from, config.Version)
+		}
+		return nil
+	},
+}
+
+var whichConfigCommand = &cli.Command{
+	Name:  "which",
+	Usage: "print the config files in use, lowest precedence first",
+	Description: `The configuration is merged from, in increasing precedence:
+the system configs in $XDG_CONFIG_DIRS/asm (default /etc/xdg/asm), the user
+config in the user config dir, the project configs from the repository root
+down to the working directory and finally the files given with --config or,
+without it, $ASM_CONFIG. $ASM_CONFIG lists files like $PATH, separated by
+colons (semicolons on Windows).`,
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:  "active",
+			Usage: "only print the file that nodes add, set and rm change",
+		},
+	},
+	Action: func(cx *cli.Context) error {
+		if cx.Bool("active") {
+			fn, err := config.ActiveFile(configFiles(cx))
+			if err != nil {
+				return err
+			}
+			fmt.Fprintln(cx.App.Writer, fn)
+			return nil
+		}
+
+		fns, err := config.Files(configFiles(cx))
+		if err != nil {
+			return err
+		}
+		if len(fns) == 0 {
+			return errors.New("no config file found")
+		}
+		for _, fn := range fns {
+			fmt.Fprintln(cx.App.Writer, fn)
+		}
+		return nil
+	},
+}
@@ -1,10 +1,22 @@
 package main
 
 import (
-	"github.com/containerd/containerd/platforms"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
 	"github.com/docker/buildx/store"
 	"github.com/docker/buildx/util/platformutil"
+	"github.com/docker/cli/cli/command"
+	dockerconfig "github.com/docker/cli/cli/config"
+	ctxdocker "github.com/docker/cli/cli/context/docker"
+	ctxstore "github.com/docker/cli/cli/context/store"
 	dockerclient "github.com/docker/docker/client"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
 	cli "github.com/urfave/cli/v2"
 
 	"github.com/robertgzr/asm/config"
@@ -19,6 +31,7 @@ var genCommand = &cli.Command{
 		&cli.StringFlag{
 			Name:    "format",
 			Aliases: []string{"f"},
+			Usage:   "output format (yaml, json, hcl)",
 			Value:   "yaml",
 		},
 	},
@@ -27,6 +40,9 @@ var genCommand = &cli.Command{
 	},
 	Subcommands: []*cli.Command{
 		genDockerCommand,
+		genBuildxCommand,
+		genContextsCommand,
+		genSchemaCommand,
 	},
 }
 
@@ -42,26 +58,265 @@ var genDockerCommand = &cli.Command{
 		},
 		&cli.StringSliceFlag{
 			Name:  "platform",
-			Usage: "platforms supported by this docker daemon",
-			Value: cli.NewStringSlice(platforms.DefaultString()),
+			Usage: "platforms supported by this docker daemon, detected when building if omitted",
 		},
 	},
 	Action: func(cx *cli.Context) error {
 		node := config.Node{
-			Driver: "docker",
-			Node: store.Node{
-				Name:     "docker daemon",
-				Endpoint: cx.String("host"),
-			},
+			Name:     "docker daemon",
+			Driver:   "docker",
+			Endpoint: cx.String("host"),
 		}
 		specs, err := platformutil.Parse(cx.StringSlice("platform"))
 		if err != nil {
 			return err
 		}
 		node.Platforms = specs
-		cfg := config.NodeGroup{
+		cfg := config.Config{
 			Nodes: []config.Node{node},
 		}
 		return config.Write(cx.App.Writer, cfg, cx.String("format"))
 	},
 }
+
+var genBuildxCommand = &cli.Command{
+	Name:      "buildx",
+	Usage:     "generate node group configs from buildx builder instances",
+	ArgsUsage: "[NAME...]",
+	Action: func(cx *cli.Context) error {
+		root := os.Getenv("BUILDX_CONFIG")
+		if root == "" {
+			root = filepath.Join(dockerconfig.Dir(), "buildx")
+		}
+		s, err := store.New(root)
+		if err != nil {
+			return err
+		}
+		txn, release, err := s.Txn()
+		if err != nil {
+			return err
+		}
+		defer release()
+
+		var ngs []*store.NodeGroup
+		if cx.Args().Present() {
+			for _, name := range cx.Args().Slice() {
+				ng, err := txn.NodeGroupByName(name)
+				if err != nil {
+					return errors.Wrapf(err, "reading builder %s", name)
+				}
+				ngs = append(ngs, ng)
+			}
+		} else if ngs, err = txn.List(); err != nil {
+			return err
+		}
+		if len(ngs) == 0 {
+			return errors.Errorf("no builders found in %s", root)
+		}
+
+		// a single builder becomes the default group, multiple ones get a
+		// group each
+		var cfg config.Config
+		for _, ng := range ngs {
+			var nodes []config.Node
+			for _, n := range ng.Nodes {
+				nodes = append(nodes, buildxNode(ng.Driver, n))
+			}
+			if len(ngs) == 1 {
+				cfg.Nodes = nodes
+				break
+			}
+			if cfg.Groups == nil {
+				cfg.Default = ng.Name
+				cfg.Groups = make(map[string]config.NodeGroup)
+			}
+			cfg.Groups[ng.Name] = config.NodeGroup{Nodes: nodes}
+		}
+		return config.Write(cx.App.Writer, cfg, cx.String("format"))
+	},
+}
+
+var genContextsCommand = &cli.Command{
+	Name:      "contexts",
+	Usage:     "generate node group configs from docker contexts",
+	ArgsUsage: "[NAME...]",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "driver",
+			Usage: "driver to use for the nodes",
+			Value: "docker",
+		},
+		&cli.StringSliceFlag{
+			Name:  "platform",
+			Usage: "platforms supported by the docker daemons",
+		},
+	},
+	Action: func(cx *cli.Context) error {
+		specs, err := platformutil.Parse(cx.StringSlice("platform"))
+		if err != nil {
+			return err
+		}
+
+		s := dockerContextStore()
+		names := cx.Args().Slice()
+		if len(names) == 0 {
+			names = append(names, "default")
+			metas, err := s.List()
+			if err != nil {
+				return err
+			}
+			for _, m := range metas {
+				names = append(names, m.Name)
+			}
+		}
+
+		var cfg config.Config
+		for _, name := range names {
+			node, err := contextNode(s, name)
+			if err != nil {
+				return err
+			}
+			node.Driver = cx.String("driver")
+			node.Platforms = specs
+			cfg.Nodes = append(cfg.Nodes, node)
+		}
+		return config.Write(cx.App.Writer, cfg, cx.String("format"))
+	},
+}
+
+func dockerContextStore() ctxstore.Store {
+	return ctxstore.New(filepath.Join(dockerconfig.Dir(), "contexts"), ctxstore.NewConfig(
+		func() interface{} { return &command.DockerContext{} },
+		ctxstore.EndpointTypeGetter(ctxdocker.DockerEndpoint, func() interface{} { return &ctxdocker.EndpointMeta{} }),
+	))
+}
+
+// contextNode returns a node for the docker endpoint of the context called
+// name, its tls material is referenced from the context store.
+func contextNode(s ctxstore.Store, name string) (config.Node, error) {
+	node := config.Node{Name: name}
+	if name == "default" {
+		node.Endpoint = dockerclient.DefaultDockerHost
+		if host := os.Getenv("DOCKER_HOST"); host != "" {
+			node.Endpoint = host
+		}
+		return node, nil
+	}
+
+	meta, err := s.GetMetadata(name)
+	if err != nil {
+		return node, err
+	}
+	ep, err := ctxdocker.EndpointFromContext(meta)
+	if err != nil {
+		return node, errors.Wrapf(err, "context %s", name)
+	}
+	node.Endpoint = ep.Host
+
+	files, err := s.ListTLSFiles(name)
+	if err != nil {
+		return node, err
+	}
+	tlsDir := filepath.Join(s.GetStorageInfo(name).TLSPath, ctxdocker.DockerEndpoint)
+	for _, fn := range files[ctxdocker.DockerEndpoint] {
+		if node.TLS == nil {
+			node.TLS = &config.TLS{}
+		}
+		switch fn {
+		case "ca.pem":
+			node.TLS.CA = filepath.Join(tlsDir, fn)
+		case "cert.pem":
+			node.TLS.Cert = filepath.Join(tlsDir, fn)
+		case "key.pem":
+			node.TLS.Key = filepath.Join(tlsDir, fn)
+		}
+	}
+	if ep.SkipTLSVerify {
+		if node.TLS == nil {
+			node.TLS = &config.TLS{}
+		}
+		verify := false
+		node.TLS.Verify = &verify
+	}
+	return node, nil
+}
+
+// buildxNode maps the buildx node n using driver to a node.
+func buildxNode(driver string, n store.Node) config.Node {
+	node := config.Node{
+		Name:       n.Name,
+		Driver:     driver,
+		Platforms:  n.Platforms,
+		Flags:      n.Flags,
+		DriverOpts: n.DriverOpts,
+		Files:      n.Files,
+	}
+	if driver == "kubernetes" {
+		node.Kubernetes, node.DriverOpts = buildxKubernetes(n.Endpoint, n.DriverOpts)
+		return node
+	}
+	node.Endpoint, node.TLS = buildxEndpoint(n.Endpoint)
+	return node
+}
+
+// buildxKubernetes maps the endpoint and driver options of a buildx
+// kubernetes node, like kubernetes:///name?deployment=name0&kubeconfig=path,
+// to a kubernetes block and the remaining driver options.
+func buildxKubernetes(endpoint string, driverOpts map[string]string) (*config.Kubernetes, map[string]string) {
+	k := &config.Kubernetes{}
+	if u, err := url.Parse(endpoint); err == nil && u.Scheme == "kubernetes" {
+		k.Kubeconfig = u.Query().Get("kubeconfig")
+	} else if endpoint != "" {
+		logrus.Warnf("kubernetes endpoint %s is not supported, using the default kubeconfig", endpoint)
+	}
+
+	var opts map[string]string
+	for key, v := range driverOpts {
+		switch key {
+		case "namespace":
+			k.Namespace = v
+			continue
+		case "replicas":
+			if r, err := strconv.Atoi(v); err == nil {
+				k.Replicas = r
+				continue
+			}
+		}
+		if opts == nil {
+			opts = make(map[string]string)
+		}
+		opts[key] = v
+	}
+	if *k == (config.Kubernetes{}) {
+		k = nil
+	}
+	return k, opts
+}
+
+// buildxEndpoint maps the endpoint of a buildx node, which is either an
+// address or the name of a docker context, to an address and the tls config
+// needed to connect to it.
+func buildxEndpoint(endpoint string) (string, *config.TLS) {
+	if endpoint == "" || strings.Contains(endpoint, "://") {
+		return endpoint, nil
+	}
+	node, err := contextNode(dockerContextStore(), endpoint)
+	if err != nil {
+		logrus.WithError(err).Warnf("failed to resolve docker context %s, keeping it as is", endpoint)
+		return endpoint, nil
+	}
+	return node.Endpoint, node.TLS
+}
+
+var genSchemaCommand = &cli.Command{
+	Name:  "schema",
+	Usage: "generate a JSON schema of the node config, ignores --format",
+	Action: func(cx *cli.Context) error {
+		b, err := config.Schema()
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(cx.App.Writer, "%s\n", b)
+		return err
+	},
+}
@@ -0,0 +1,95 @@
+//go:build podman
+// +build podman
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/containerd/containerd/platforms"
+	"github.com/docker/buildx/driver/bkimage"
+	specs "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/sirupsen/logrus"
+	cli "github.com/urfave/cli/v2"
+
+	"github.com/robertgzr/asm/config"
+	"github.com/robertgzr/asm/driver/podman"
+)
+
+func init() {
+	genCommand.Subcommands = append(genCommand.Subcommands, genPodmanCommand)
+}
+
+var genPodmanCommand = &cli.Command{
+	Name:  "podman",
+	Usage: "generate podman node group configs from the local podman",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "name",
+			Usage: "name of the node",
+			Value: "podman",
+		},
+		&cli.StringFlag{
+			Name:  "image",
+			Usage: "buildkit image to run",
+			Value: "docker.io/" + bkimage.DefaultImage,
+		},
+	},
+	Action: func(cx *cli.Context) error {
+		info, err := podman.Info()
+		if err != nil {
+			return err
+		}
+		logrus.
+			WithField("version", info.Version.Version).
+			WithField("rootless", info.Host.Security.Rootless).
+			Debug("probed podman")
+
+		node := config.Node{
+			Name:   cx.String("name"),
+			Driver: "podman",
+			Platforms: []specs.Platform{
+				platforms.Normalize(specs.Platform{OS: info.Host.OS, Architecture: info.Host.Arch}),
+			},
+			DriverOpts: map[string]string{
+				"image": cx.String("image"),
+			},
+		}
+		if info.Host.Security.Rootless {
+			// rootless podman is used through its docker compatible api
+			// socket, which buildx supports with the docker-container
+			// driver
+			node.Driver = "docker-container"
+			node.Endpoint = podmanSocket(info)
+			logrus.Infof("podman runs rootless, generated a docker-container node using the podman socket %s", node.Endpoint)
+			if !info.Host.RemoteSocket.Exists {
+				logrus.Warn("the podman socket is not running, start it with `systemctl --user enable --now podman.socket`")
+			}
+		}
+
+		cfg := config.Config{
+			Nodes: []config.Node{node},
+		}
+		return config.Write(cx.App.Writer, cfg, cx.String("format"))
+	},
+}
+
+// podmanSocket returns the endpoint of the podman api socket of the current
+// user.
+func podmanSocket(info *podman.HostInfo) string {
+	path := info.Host.RemoteSocket.Path
+	if path == "" {
+		dir := os.Getenv("XDG_RUNTIME_DIR")
+		if dir == "" {
+			dir = filepath.Join("/run/user", strconv.Itoa(os.Getuid()))
+		}
+		path = filepath.Join(dir, "podman", "podman.sock")
+	}
+	if !strings.Contains(path, "://") {
+		path = "unix://" + path
+	}
+	return path
+}
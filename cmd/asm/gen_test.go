@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/docker/buildx/store"
+	cli "github.com/urfave/cli/v2"
+
+	"github.com/robertgzr/asm/config"
+)
+
+func TestBuildxKubernetes(t *testing.T) {
+	for _, tc := range []struct {
+		endpoint   string
+		driverOpts map[string]string
+		want       *config.Kubernetes
+		wantOpts   map[string]string
+	}{
+		{
+			endpoint: "kubernetes:///kb?deployment=kb0&kubeconfig=",
+		},
+		{
+			endpoint:   "kubernetes:///kb?deployment=kb0&kubeconfig=%2Fhome%2Fme%2F.kube%2Fconfig",
+			driverOpts: map[string]string{"namespace": "ci", "replicas": "2", "image": "moby/buildkit"},
+			want:       &config.Kubernetes{Kubeconfig: "/home/me/.kube/config", Namespace: "ci", Replicas: 2},
+			wantOpts:   map[string]string{"image": "moby/buildkit"},
+		},
+		{
+			endpoint:   "kubernetes:///kb?deployment=kb0&kubeconfig=",
+			driverOpts: map[string]string{"replicas": "many"},
+			wantOpts:   map[string]string{"replicas": "many"},
+		},
+	} {
+		k, opts := buildxKubernetes(tc.endpoint, tc.driverOpts)
+		if !reflect.DeepEqual(k, tc.want) {
+			t.Errorf("%s: expected %+v, got %+v", tc.endpoint, tc.want, k)
+		}
+		if !reflect.DeepEqual(opts, tc.wantOpts) {
+			t.Errorf("%s: expected driver options %v, got %v", tc.endpoint, tc.wantOpts, opts)
+		}
+	}
+}
+
+func TestGenBuildx(t *testing.T) {
+	dir := t.TempDir()
+	old, ok := os.LookupEnv("BUILDX_CONFIG")
+	os.Setenv("BUILDX_CONFIG", dir)
+	t.Cleanup(func() {
+		if ok {
+			os.Setenv("BUILDX_CONFIG", old)
+		} else {
+			os.Unsetenv("BUILDX_CONFIG")
+		}
+	})
+
+	s, err := store.New(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	txn, release, err := s.Txn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, ng := range []*store.NodeGroup{
+		{
+			Name:   "kb",
+			Driver: "kubernetes",
+			Nodes: []store.Node{{
+				Name:       "kb0",
+				Endpoint:   "kubernetes:///kb?deployment=kb0&kubeconfig=",
+				DriverOpts: map[string]string{"namespace": "ci", "replicas": "2"},
+			}},
+		},
+		{
+			Name:   "local",
+			Driver: "docker-container",
+			Nodes: []store.Node{{
+				Name:     "local0",
+				Endpoint: "unix:///var/run/docker.sock",
+			}},
+		},
+	} {
+		if err := txn.Save(ng); err != nil {
+			t.Fatal(err)
+		}
+	}
+	release()
+
+	var out bytes.Buffer
+	app := &cli.App{
+		Writer:   &out,
+		Commands: []*cli.Command{genCommand},
+	}
+	if err := app.Run([]string{"asm", "gen", "buildx"}); err != nil {
+		t.Fatal(err)
+	}
+
+	fn := filepath.Join(t.TempDir(), "asm.yml")
+	if err := os.WriteFile(fn, out.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := config.Parse(fn)
+	if err != nil {
+		t.Fatalf("%s\n%s", err, out.Bytes())
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("%s\n%s", err, out.Bytes())
+	}
+
+	kb := cfg.Groups["kb"].Nodes
+	if len(kb) != 1 || kb[0].Endpoint != "" || kb[0].Kubernetes == nil || kb[0].Kubernetes.Namespace != "ci" || kb[0].Kubernetes.Replicas != 2 {
+		t.Errorf("expected a kubernetes block instead of an endpoint, got %+v", kb)
+	}
+	if local := cfg.Groups["local"].Nodes; len(local) != 1 || local[0].Endpoint != "unix:///var/run/docker.sock" {
+		t.Errorf("expected the docker endpoint to be kept, got %+v", local)
+	}
+}
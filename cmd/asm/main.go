@@ -5,6 +5,8 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"path/filepath"
+	"time"
 
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
@@ -13,8 +15,6 @@ import (
 	_ "github.com/docker/buildx/driver/docker"
 	_ "github.com/docker/buildx/driver/docker-container"
 
-	// _ "github.com/docker/buildx/driver/kubernetes"
-
 	"github.com/moby/buildkit/util/tracing/detect"
 	_ "github.com/moby/buildkit/util/tracing/detect/delegated"
 	_ "github.com/moby/buildkit/util/tracing/env"
@@ -24,7 +24,10 @@ import (
 	"github.com/robertgzr/asm/version"
 )
 
-type ctxKeyConfig struct{}
+type (
+	ctxKeyConfig     struct{}
+	ctxKeyConfigFile struct{}
+)
 
 func init() {
 	detect.ServiceName = "asm"
@@ -47,11 +50,29 @@ func main() {
 			Name:  "debug",
 			Usage: "be more verbose",
 		},
-		&cli.StringFlag{
+		&cli.StringSliceFlag{
 			Name:    "config",
 			Aliases: []string{"c"},
-			Value:   "",
-			Usage:   "config file with worker infos",
+			Usage:   "config file with worker infos, merged on top of the discovered ones, defaults to the files listed in $ASM_CONFIG",
+		},
+		&cli.StringFlag{
+			Name:    "group",
+			Aliases: []string{"g"},
+			Usage:   "node group to use",
+		},
+		&cli.StringFlag{
+			Name:    "profile",
+			Usage:   "profile to apply to the nodes",
+			EnvVars: []string{"ASM_PROFILE"},
+		},
+		&cli.DurationFlag{
+			Name:  "connect-timeout",
+			Usage: "time limit of each attempt to connect to a node without connectTimeout",
+			Value: 30 * time.Second,
+		},
+		&cli.IntFlag{
+			Name:  "connect-retries",
+			Usage: "retries of failed connections to nodes without retries",
 		},
 	}
 
@@ -61,6 +82,7 @@ func main() {
 		// serveCommand,
 		// ctlCommand,
 		nodesCommand,
+		configCommand,
 	}
 
 	app.Before = func(cx *cli.Context) error {
@@ -68,13 +90,6 @@ func main() {
 			logrus.SetLevel(logrus.DebugLevel)
 			logrus.Debug("debug output enabled")
 		}
-
-		cfg, err := config.Load(cx.String("config"))
-		if err != nil {
-			return errors.Wrap(err, "loading config")
-		}
-
-		cx.Context = context.WithValue(cx.Context, ctxKeyConfig{}, cfg)
 		return nil
 	}
 
@@ -88,6 +103,52 @@ func main() {
 	}
 }
 
+// configFiles returns the config files given with --config or, without the
+// flag, listed in $ASM_CONFIG separated like $PATH. The flag does not read the
+// variable itself as it would split it on commas.
+func configFiles(cx *cli.Context) []string {
+	if fns := cx.StringSlice("config"); len(fns) != 0 {
+		return fns
+	}
+	var fns []string
+	for _, fn := range filepath.SplitList(os.Getenv("ASM_CONFIG")) {
+		if fn != "" {
+			fns = append(fns, fn)
+		}
+	}
+	return fns
+}
+
+// loadConfig loads the node configuration and selects the requested group,
+// it is run before the commands that need nodes.
+func loadConfig(cx *cli.Context) error {
+	cfg, err := config.Load(configFiles(cx), cx.String("profile"))
+	if err != nil {
+		return errors.Wrap(err, "loading config")
+	}
+	ng, err := cfg.Group(cx.String("group"))
+	if err != nil {
+		return errors.Wrap(err, "loading config")
+	}
+	// the defaults only apply to the selected nodes, ng shares its nodes
+	// with cfg
+	ng.Nodes = append([]config.Node(nil), ng.Nodes...)
+	retries := cx.Int("connect-retries")
+	for i := range ng.Nodes {
+		n := &ng.Nodes[i]
+		if n.ConnectTimeout == 0 {
+			n.ConnectTimeout = config.Duration(cx.Duration("connect-timeout"))
+		}
+		if n.Retries == nil {
+			n.Retries = &retries
+		}
+	}
+
+	cx.Context = context.WithValue(cx.Context, ctxKeyConfigFile{}, cfg)
+	cx.Context = context.WithValue(cx.Context, ctxKeyConfig{}, ng)
+	return nil
+}
+
 type skipErrors struct{}
 
 func (skipErrors) Handle(err error) {}
@@ -1,14 +1,33 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
 	"strings"
+	"sync"
+	"syscall"
 	"text/tabwriter"
+	"time"
 
 	"github.com/containerd/containerd/platforms"
+	"github.com/docker/buildx/build"
+	"github.com/docker/buildx/driver"
+	"github.com/docker/buildx/util/platformutil"
+	"github.com/docker/buildx/util/progress"
+	units "github.com/docker/go-units"
+	"github.com/moby/buildkit/client"
 	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
 	cli "github.com/urfave/cli/v2"
+	"golang.org/x/sync/errgroup"
 
+	"github.com/robertgzr/asm"
 	"github.com/robertgzr/asm/config"
 )
 
@@ -16,22 +35,44 @@ var nodesCommand = &cli.Command{
 	Name:    "nodes",
 	Aliases: []string{},
 	Usage:   "interact with build nodes",
+	Description: `The add, rm and set commands edit the node config, bootstrap, stop,
+destroy and prune manage the buildkit instances of the nodes. Removing
+the instances of nodes is called destroy, not rm, to keep it apart from
+removing nodes from the config.`,
 	Action: func(cx *cli.Context) error {
+		if err := loadConfig(cx); err != nil {
+			return err
+		}
 		return listNodes(cx)
 	},
-	Subcommands: []*cli.Command{listNodesCommand},
+	Subcommands: []*cli.Command{
+		listNodesCommand,
+		inspectNodesCommand,
+		bootstrapNodesCommand,
+		stopNodesCommand,
+		destroyNodesCommand,
+		pruneNodesCommand,
+		addNodeCommand,
+		removeNodeCommand,
+		setNodeCommand,
+	},
 }
 
 var listNodesCommand = &cli.Command{
 	Name:    "list",
 	Aliases: []string{"ls"},
 	Usage:   "list build nodes",
+	Before:  loadConfig,
 	Flags: []cli.Flag{
 		&cli.StringSliceFlag{
 			Name:  "platform",
 			Usage: "platforms supported by this docker daemon",
 			Value: cli.NewStringSlice(platforms.DefaultString()),
 		},
+		&cli.BoolFlag{
+			Name:  "sources",
+			Usage: "show the config files each node was declared in",
+		},
 	},
 	Action: listNodes,
 }
@@ -44,16 +85,684 @@ func formatPlatformArray(array []v1.Platform) string {
 	return strings.Join(ps, ",")
 }
 
+func formatLabels(labels map[string]string) string {
+	var ls []string
+	for k, v := range labels {
+		ls = append(ls, k+"="+v)
+	}
+	sort.Strings(ls)
+	return strings.Join(ls, ",")
+}
+
 func listNodes(cx *cli.Context) error {
-	cfg := cx.Context.Value(ctxKeyConfig{}).(config.NodeGroup)
+	// show every group unless one was selected explicitly
+	ngs := cx.Context.Value(ctxKeyConfigFile{}).(config.Config).AllGroups()
+	if cx.String("group") != "" {
+		ngs = []config.NodeGroup{cx.Context.Value(ctxKeyConfig{}).(config.NodeGroup)}
+	}
 
 	tw := tabwriter.NewWriter(cx.App.Writer, 0, 4, 4, ' ', tabwriter.TabIndent)
+	defer tw.Flush()
+
+	sources := cx.Bool("sources")
+
+	fmt.Fprintf(tw, "GROUP\tNAME\tDRIVER\tENDPOINT\tPLATFORMS\tLABELS")
+	if sources {
+		fmt.Fprintf(tw, "\tSOURCES")
+	}
+	fmt.Fprintln(tw)
+	for _, ng := range ngs {
+		for _, n := range ng.Nodes {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s", ng.Name, n.Name, n.Driver, n.Endpoint, formatPlatformArray(n.Platforms), formatLabels(n.Labels))
+			if sources {
+				fmt.Fprintf(tw, "\t%s", strings.Join(n.Sources, ","))
+			}
+			fmt.Fprintln(tw)
+		}
+	}
+
+	return nil
+}
+
+var inspectNodesCommand = &cli.Command{
+	Name:      "inspect",
+	Usage:     "show the live status of build nodes",
+	ArgsUsage: "[NAME]",
+	Before:    loadConfig,
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:  "bootstrap",
+			Usage: "start nodes that are not running",
+		},
+	},
+	Action: inspectNodes,
+}
+
+func inspectNodes(cx *cli.Context) error {
+	if cx.NArg() > 1 {
+		return errors.New("expected at most one node name")
+	}
+	ng, err := argNodes(cx)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	contextPathHash, _ := os.Getwd()
+	dis, err := asm.DriversForNodeGroup(ctx, &ng, contextPathHash)
+	if err != nil {
+		return err
+	}
+	if cx.Bool("bootstrap") {
+		printer := progress.NewPrinter(ctx, os.Stderr, "auto")
+		err := asm.Boot(ctx, dis, printer)
+		if err1 := printer.Wait(); err == nil {
+			err = err1
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	tw := tabwriter.NewWriter(cx.App.Writer, 0, 4, 1, ' ', 0)
+	defer tw.Flush()
+	for i, n := range ng.Nodes {
+		if i != 0 {
+			fmt.Fprintln(tw)
+		}
+		fmt.Fprintf(tw, "Name:\t%s\n", n.Name)
+		fmt.Fprintf(tw, "Group:\t%s\n", ng.Name)
+		fmt.Fprintf(tw, "Driver:\t%s\n", n.Driver)
+		if n.Endpoint != "" {
+			fmt.Fprintf(tw, "Endpoint:\t%s\n", n.Endpoint)
+		}
+		if len(n.Labels) != 0 {
+			fmt.Fprintf(tw, "Labels:\t%s\n", formatLabels(n.Labels))
+		}
+		if err := dis[i].Err; err != nil {
+			fmt.Fprintf(tw, "Error:\t%s\n", err)
+			continue
+		}
+		ni, err := inspectNode(ctx, n, dis[i].Driver)
+		if ni != nil {
+			fmt.Fprintf(tw, "Status:\t%s\n", ni.Status)
+			if ni.BuildKit != "" {
+				fmt.Fprintf(tw, "BuildKit:\t%s\n", ni.BuildKit)
+			}
+		}
+		if err != nil {
+			fmt.Fprintf(tw, "Error:\t%s\n", err)
+			continue
+		}
+		if len(n.Flags) != 0 {
+			fmt.Fprintf(tw, "Flags:\t%s\n", strings.Join(n.Flags, " "))
+		}
+		// configured platforms are marked with a *
+		var detected []v1.Platform
+		for _, w := range ni.Workers {
+			detected = append(detected, w.Platforms...)
+		}
+		if ps := platformutil.FormatInGroups(n.Platforms, detected); len(ps) != 0 {
+			fmt.Fprintf(tw, "Platforms:\t%s\n", strings.Join(ps, ", "))
+		}
+		for _, w := range ni.Workers {
+			printWorker(tw, w)
+		}
+	}
+	return nil
+}
+
+// inspectNode gives up on n after its connect timeout.
+func inspectNode(ctx context.Context, n config.Node, d driver.Driver) (*asm.NodeInfo, error) {
+	if timeout := time.Duration(n.ConnectTimeout); timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	return asm.Inspect(ctx, d)
+}
+
+func printWorker(w io.Writer, wi *client.WorkerInfo) {
+	fmt.Fprintf(w, "Worker:\t%s\n", wi.ID)
+	fmt.Fprintf(w, " Platforms:\t%s\n", strings.Join(platformutil.Format(wi.Platforms), ", "))
+	keys := make([]string, 0, len(wi.Labels))
+	for k := range wi.Labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	if len(keys) != 0 {
+		fmt.Fprintln(w, " Labels:\t")
+	}
+	for _, k := range keys {
+		// not aligned, the keys are long
+		fmt.Fprintf(w, "  %s=%s\n", k, wi.Labels[k])
+	}
+	for _, p := range wi.GCPolicy {
+		fmt.Fprintln(w, " GC Policy rule:\t")
+		fmt.Fprintf(w, "  All:\t%v\n", p.All)
+		if len(p.Filter) != 0 {
+			fmt.Fprintf(w, "  Filters:\t%s\n", strings.Join(p.Filter, " "))
+		}
+		if p.KeepDuration != 0 {
+			fmt.Fprintf(w, "  Keep Duration:\t%s\n", p.KeepDuration)
+		}
+		if p.KeepBytes != 0 {
+			fmt.Fprintf(w, "  Keep Bytes:\t%s\n", units.BytesSize(float64(p.KeepBytes)))
+		}
+	}
+}
 
-	fmt.Fprintf(tw, "NAME\tDRIVER\tENDPOINT\tPLATFORMS\n")
-	for _, n := range cfg.Nodes {
+// argNodes returns the selected node group, limited to the nodes named by
+// the arguments if there are any.
+func argNodes(cx *cli.Context) (config.NodeGroup, error) {
+	ng := cx.Context.Value(ctxKeyConfig{}).(config.NodeGroup)
+	if !cx.Args().Present() {
+		return ng, nil
+	}
+	var nodes []config.Node
+	seen := make(map[string]bool)
+next:
+	for _, name := range cx.Args().Slice() {
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		for _, n := range ng.Nodes {
+			if n.Name == name {
+				nodes = append(nodes, n)
+				continue next
+			}
+		}
+		return ng, errors.Errorf("no such node: %s", name)
+	}
+	ng.Nodes = nodes
+	return ng, nil
+}
+
+var progressFlag = &cli.StringFlag{
+	Name:  "progress",
+	Value: "auto",
+	Usage: "set type of progress output (auto, plain, tty)",
+}
+
+var bootstrapNodesCommand = &cli.Command{
+	Name:      "bootstrap",
+	Usage:     "start the buildkit instances of build nodes",
+	ArgsUsage: "[NAME...]",
+	Before:    loadConfig,
+	Flags:     []cli.Flag{progressFlag},
+	Action: func(cx *cli.Context) error {
+		return forEachDriver(cx, "start", func(ctx context.Context, di build.DriverInfo, pw progress.Writer) error {
+			c, err := driver.Boot(ctx, ctx, di.Driver, pw)
+			if err != nil {
+				return err
+			}
+			return c.Close()
+		})
+	},
+}
+
+var stopNodesCommand = &cli.Command{
+	Name:      "stop",
+	Usage:     "stop the buildkit instances of build nodes",
+	ArgsUsage: "[NAME...]",
+	Before:    loadConfig,
+	Flags:     []cli.Flag{progressFlag},
+	Action: func(cx *cli.Context) error {
+		return forEachDriver(cx, "stop", func(ctx context.Context, di build.DriverInfo, pw progress.Writer) error {
+			return progress.Wrap("stopping buildkit", pw.Write, func(progress.SubLogger) error {
+				return di.Driver.Stop(ctx, true)
+			})
+		})
+	},
+}
+
+var destroyNodesCommand = &cli.Command{
+	Name:      "destroy",
+	Usage:     "remove the buildkit instances of build nodes, the config is kept",
+	ArgsUsage: "[NAME...]",
+	Description: `Removes the containers, pods or tasks running buildkit for the nodes. The
+nodes stay in the config, asm nodes rm removes them from the config instead.`,
+	Before: loadConfig,
+	Flags: []cli.Flag{
+		progressFlag,
+		&cli.BoolFlag{
+			Name:  "volumes",
+			Usage: "also remove the volumes holding the buildkit state",
+		},
+	},
+	Action: func(cx *cli.Context) error {
+		volumes := cx.Bool("volumes")
+		return forEachDriver(cx, "destroy", func(ctx context.Context, di build.DriverInfo, pw progress.Writer) error {
+			return progress.Wrap("removing buildkit", pw.Write, func(progress.SubLogger) error {
+				if err := di.Driver.Stop(ctx, true); err != nil {
+					return err
+				}
+				return di.Driver.Rm(ctx, true, volumes)
+			})
+		})
+	},
+}
+
+var pruneNodesCommand = &cli.Command{
+	Name:      "prune",
+	Usage:     "remove the build cache of build nodes",
+	ArgsUsage: "[NAME...]",
+	Before:    loadConfig,
+	Flags: []cli.Flag{
+		progressFlag,
+		&cli.BoolFlag{
+			Name:  "all",
+			Usage: "remove all cache, not just dangling records",
+		},
+		&cli.StringSliceFlag{
+			Name:  "filter",
+			Usage: "only remove matching records, like type=source.local",
+		},
+		&cli.DurationFlag{
+			Name:  "keep-duration",
+			Usage: "keep records used within this duration",
+		},
+	},
+	Action: func(cx *cli.Context) error {
+		opts := []client.PruneOption{
+			client.WithFilter(cx.StringSlice("filter")),
+			client.WithKeepOpt(cx.Duration("keep-duration"), 0),
+		}
+		if cx.Bool("all") {
+			opts = append(opts, client.PruneAll)
+		}
+
+		var (
+			mu        sync.Mutex
+			reclaimed = make(map[string]int64)
+		)
+		err := forEachDriver(cx, "prune", func(ctx context.Context, di build.DriverInfo, pw progress.Writer) error {
+			return progress.Wrap("pruning build cache", pw.Write, func(progress.SubLogger) error {
+				info, err := di.Driver.Info(ctx)
+				if err != nil {
+					return err
+				}
+				if info.Status != driver.Running {
+					// a stopped node keeps its cache, but cannot prune it
+					return nil
+				}
+				c, err := di.Driver.Client(ctx)
+				if err != nil {
+					return err
+				}
+				defer c.Close()
+
+				ch := make(chan client.UsageInfo)
+				done := make(chan int64)
+				go func() {
+					var size int64
+					for du := range ch {
+						size += du.Size
+					}
+					done <- size
+				}()
+				err = c.Prune(ctx, ch, opts...)
+				close(ch)
+				size := <-done
+				if err != nil {
+					return err
+				}
+
+				mu.Lock()
+				reclaimed[di.Name] = size
+				mu.Unlock()
+				return nil
+			})
+		})
+
+		tw := tabwriter.NewWriter(cx.App.Writer, 0, 4, 4, ' ', tabwriter.TabIndent)
 		defer tw.Flush()
-		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", n.Name, n.Driver, n.Endpoint, formatPlatformArray(n.Platforms))
+		fmt.Fprintln(tw, "NODE\tRECLAIMED")
+		names := make([]string, 0, len(reclaimed))
+		for name := range reclaimed {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Fprintf(tw, "%s\t%s\n", name, units.HumanSize(float64(reclaimed[name])))
+		}
+		return err
+	},
+}
+
+// forEachDriver connects to the nodes named by the arguments, or every node
+// of the selected group, and runs fn for each of them, printing its progress.
+func forEachDriver(cx *cli.Context, action string, fn func(ctx context.Context, di build.DriverInfo, pw progress.Writer) error) error {
+	ng, err := argNodes(cx)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	contextPathHash, _ := os.Getwd()
+	dis, err := asm.DriversForNodeGroup(ctx, &ng, contextPathHash)
+	if err != nil {
+		return err
+	}
+
+	ctx2, cancelPrinter := context.WithCancel(context.TODO())
+	defer cancelPrinter()
+	printer := progress.NewPrinter(ctx2, os.Stderr, cx.String("progress"))
+	eg, _ := errgroup.WithContext(ctx)
+	for i := range dis {
+		if dis[i].Err != nil || dis[i].Driver == nil {
+			continue
+		}
+		func(i int) {
+			eg.Go(func() error {
+				pw := progress.WithPrefix(printer, dis[i].Name, len(dis) > 1)
+				dis[i].Err = fn(ctx, dis[i], pw)
+				return nil
+			})
+		}(i)
+	}
+	err = eg.Wait()
+	if err1 := printer.Wait(); err == nil {
+		err = err1
+	}
+	if err != nil {
+		return err
+	}
+
+	var failed int
+	for _, di := range dis {
+		if di.Err != nil {
+			failed++
+			fmt.Fprintf(cx.App.ErrWriter, "%s: %s\n", di.Name, di.Err)
+		}
+	}
+	if failed != 0 {
+		return errors.Errorf("failed to %s %d of %d nodes", action, failed, len(dis))
+	}
+	return nil
+}
+
+// nodeFlags set the fields of a node in `nodes add` and `nodes set`
+var nodeFlags = []cli.Flag{
+	&cli.StringFlag{
+		Name:  "driver",
+		Usage: "driver used for the node",
+	},
+	&cli.StringFlag{
+		Name:  "endpoint",
+		Usage: "address of the docker daemon, or of buildkitd for the remote driver",
+	},
+	&cli.StringSliceFlag{
+		Name:  "platform",
+		Usage: "platforms supported by the node",
+	},
+	&cli.StringSliceFlag{
+		Name:  "label",
+		Usage: "label of the node (key=value)",
+	},
+	&cli.StringSliceFlag{
+		Name:  "driver-opt",
+		Usage: "driver option (key=value)",
+	},
+	&cli.StringSliceFlag{
+		Name:  "buildkitd-flag",
+		Usage: "flag passed to buildkitd",
+	},
+	&cli.StringFlag{
+		Name:  "buildkit-config",
+		Usage: "buildkitd config file",
+	},
+	&cli.StringFlag{
+		Name:  "tls-ca",
+		Usage: "CA certificate the endpoint is verified with",
+	},
+	&cli.StringFlag{
+		Name:  "tls-cert",
+		Usage: "client certificate",
+	},
+	&cli.StringFlag{
+		Name:  "tls-key",
+		Usage: "client key",
+	},
+	&cli.StringFlag{
+		Name:  "tls-server-name",
+		Usage: "name the server certificate is verified for",
+	},
+	&cli.BoolFlag{
+		Name:  "tls-verify",
+		Usage: "verify the server certificate",
+		Value: true,
+	},
+	&cli.StringFlag{
+		Name:  "ssh-identity-file",
+		Usage: "private key used to log in to ssh:// endpoints",
+	},
+	&cli.IntFlag{
+		Name:  "ssh-port",
+		Usage: "port of the ssh server",
+	},
+	&cli.StringFlag{
+		Name:  "ssh-known-hosts",
+		Usage: "known_hosts file the host key is checked against",
+	},
+	&cli.StringFlag{
+		Name:  "ssh-host-key-check",
+		Usage: "ssh StrictHostKeyChecking option (yes, no, accept-new)",
+	},
+	&cli.StringFlag{
+		Name:  "kubeconfig",
+		Usage: "kubeconfig file of the kubernetes driver",
+	},
+	&cli.StringFlag{
+		Name:  "kube-context",
+		Usage: "kubeconfig context of the kubernetes driver",
+	},
+	&cli.StringFlag{
+		Name:  "kube-namespace",
+		Usage: "namespace the kubernetes driver deploys buildkit to",
+	},
+	&cli.IntFlag{
+		Name:  "kube-replicas",
+		Usage: "number of buildkit pods of the kubernetes driver",
+	},
+}
+
+var addNodeCommand = &cli.Command{
+	Name:      "add",
+	Usage:     "add a build node to the active config file",
+	ArgsUsage: "NAME",
+	Flags:     nodeFlags,
+	Action: func(cx *cli.Context) error {
+		if cx.NArg() != 1 {
+			return errors.New("expected a single node name")
+		}
+		return editConfig(cx, func(f *config.File) error {
+			n, err := nodeFromFlags(cx, filepath.Dir(f.Path))
+			if err != nil {
+				return err
+			}
+			return f.AddNode(cx.String("group"), n)
+		}, "added node %s", cx.Args().First())
+	},
+}
+
+var removeNodeCommand = &cli.Command{
+	Name:      "rm",
+	Aliases:   []string{"remove"},
+	Usage:     "remove build nodes from the active config file",
+	ArgsUsage: "NAME...",
+	Description: `Removes the nodes from the config file, their buildkit instances are left
+as they are. asm nodes destroy removes those.`,
+	Action: func(cx *cli.Context) error {
+		if cx.NArg() == 0 {
+			return errors.New("expected at least one node name")
+		}
+		names := cx.Args().Slice()
+		return editConfig(cx, func(f *config.File) error {
+			for _, name := range names {
+				if err := f.RemoveNode(cx.String("group"), name); err != nil {
+					return err
+				}
+			}
+			return nil
+		}, "removed node(s) %s", strings.Join(names, ", "))
+	},
+}
+
+var setNodeCommand = &cli.Command{
+	Name:      "set",
+	Usage:     "change a build node in the active config file",
+	ArgsUsage: "NAME",
+	Flags: append([]cli.Flag{
+		&cli.StringSliceFlag{
+			Name:  "unset",
+			Usage: "remove a field, like endpoint or labels.KEY",
+		},
+	}, nodeFlags...),
+	Action: func(cx *cli.Context) error {
+		if cx.NArg() != 1 {
+			return errors.New("expected a single node name")
+		}
+		return editConfig(cx, func(f *config.File) error {
+			n, err := nodeFromFlags(cx, filepath.Dir(f.Path))
+			if err != nil {
+				return err
+			}
+			return f.SetNode(cx.String("group"), n, cx.StringSlice("unset"))
+		}, "changed node %s", cx.Args().First())
+	},
+}
+
+// editConfig applies edit to the active config file and writes it back if
+// the resulting configuration is valid.
+func editConfig(cx *cli.Context, edit func(*config.File) error, msg string, args ...interface{}) error {
+	fn, err := config.ActiveFile(configFiles(cx))
+	if err != nil {
+		return err
+	}
+	f, err := config.OpenFile(fn)
+	if err != nil {
+		return err
+	}
+	if err := edit(f); err != nil {
+		return err
+	}
+	b, err := f.Bytes()
+	if err != nil {
+		return err
+	}
+	if _, err := config.LoadWith(configFiles(cx), cx.String("profile"), fn, b); err != nil {
+		if err := printValidationErrors(cx, err); err != nil {
+			return errors.Wrapf(err, "%s not changed", fn)
+		}
 	}
 
+	mode := os.FileMode(0644)
+	if fi, err := os.Stat(fn); err == nil {
+		mode = fi.Mode()
+	}
+	if err := ioutil.WriteFile(fn, b, mode); err != nil {
+		return err
+	}
+	if f.From != config.Version {
+		fmt.Fprintf(cx.App.Writer, "%s: migrated from version %d to %d\n", fn, f.From, config.Version)
+	}
+	fmt.Fprintf(cx.App.Writer, "%s: %s\n", fn, fmt.Sprintf(msg, args...))
 	return nil
 }
+
+// nodeFromFlags returns a node with the fields set by nodeFlags, paths are
+// made relative to dir.
+func nodeFromFlags(cx *cli.Context, dir string) (config.Node, error) {
+	n := config.Node{
+		Name:     cx.Args().First(),
+		Driver:   cx.String("driver"),
+		Endpoint: cx.String("endpoint"),
+		Flags:    cx.StringSlice("buildkitd-flag"),
+	}
+	for _, s := range cx.StringSlice("platform") {
+		p, err := platforms.Parse(s)
+		if err != nil {
+			return n, err
+		}
+		n.Platforms = append(n.Platforms, p)
+	}
+
+	var err error
+	if n.Labels, err = parseKeyValues(cx.StringSlice("label")); err != nil {
+		return n, err
+	}
+	if n.DriverOpts, err = parseKeyValues(cx.StringSlice("driver-opt")); err != nil {
+		return n, err
+	}
+
+	path := func(name string) string {
+		p := cx.String(name)
+		if p == "" {
+			return ""
+		}
+		abs, err := filepath.Abs(p)
+		if err != nil {
+			return p
+		}
+		if rel, err := filepath.Rel(dir, abs); err == nil {
+			return rel
+		}
+		return abs
+	}
+	n.BuildkitConfig = path("buildkit-config")
+
+	t := config.TLS{
+		CA:         path("tls-ca"),
+		Cert:       path("tls-cert"),
+		Key:        path("tls-key"),
+		ServerName: cx.String("tls-server-name"),
+	}
+	if cx.IsSet("tls-verify") {
+		verify := cx.Bool("tls-verify")
+		t.Verify = &verify
+	}
+	if t != (config.TLS{}) {
+		n.TLS = &t
+	}
+
+	s := config.SSH{
+		IdentityFile: path("ssh-identity-file"),
+		Port:         cx.Int("ssh-port"),
+		KnownHosts:   path("ssh-known-hosts"),
+		HostKeyCheck: cx.String("ssh-host-key-check"),
+	}
+	if s != (config.SSH{}) {
+		n.SSH = &s
+	}
+
+	k := config.Kubernetes{
+		Kubeconfig: path("kubeconfig"),
+		Context:    cx.String("kube-context"),
+		Namespace:  cx.String("kube-namespace"),
+		Replicas:   cx.Int("kube-replicas"),
+	}
+	if k != (config.Kubernetes{}) {
+		n.Kubernetes = &k
+	}
+	return n, nil
+}
+
+func parseKeyValues(kvs []string) (map[string]string, error) {
+	if len(kvs) == 0 {
+		return nil, nil
+	}
+	m := make(map[string]string, len(kvs))
+	for _, kv := range kvs {
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) != 2 {
+			return nil, errors.Errorf("invalid value %q, expected key=value", kv)
+		}
+		m[parts[0]] = parts[1]
+	}
+	return m, nil
+}
@@ -2,16 +2,19 @@ package config
 
 import (
 	"encoding/json"
-	"fmt"
 	"io"
-	"io/ioutil"
 	"os"
 	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
 
-	"github.com/docker/buildx/store"
+	"github.com/containerd/containerd/platforms"
+	specs "github.com/opencontainers/image-spec/specs-go/v1"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
-	"sigs.k8s.io/yaml"
+	"gopkg.in/yaml.v3"
 )
 
 func ConfigDir() (string, error) {
@@ -28,13 +31,202 @@ func ConfigDir() (string, error) {
 	return asmDir, nil
 }
 
+// DefaultGroup is the name of the node group declared by the top-level
+// `nodes` key.
+const DefaultGroup = "default"
+
+// Config is the contents of a node configuration file.
+type Config struct {
+	// Version is the schema version the file was written in
+	Version int `json:"version"`
+	// Default names the group used when none is selected explicitly
+	Default string               `json:"default,omitempty"`
+	Nodes   []Node               `json:"nodes,omitempty"`
+	Groups  map[string]NodeGroup `json:"groups,omitempty"`
+	// Profiles change nodes when they are activated
+	Profiles map[string]Profile `json:"profiles,omitempty"`
+
+	defaultPos Position
+}
+
 type NodeGroup struct {
-	Nodes []Node
+	Name  string `json:"-"`
+	Nodes []Node `json:"nodes"`
+}
+
+// Group returns the node group called name, or the default group if name is
+// empty.
+func (c Config) Group(name string) (NodeGroup, error) {
+	if name == "" {
+		name = c.Default
+	}
+	if name == "" {
+		name = DefaultGroup
+	}
+	ng, ok := c.Groups[name]
+	if name == DefaultGroup {
+		if ok && len(c.Nodes) != 0 {
+			return NodeGroup{}, errors.Errorf("group %q is declared twice", name)
+		}
+		if !ok {
+			ng, ok = NodeGroup{Nodes: c.Nodes}, len(c.Nodes) != 0 || len(c.Groups) == 0
+		}
+	}
+	if !ok {
+		return NodeGroup{}, errors.Errorf("no such group: %s", name)
+	}
+	ng.Name = name
+	return ng, nil
+}
+
+// AllGroups returns every node group in the config, the default group first
+// followed by the others sorted by name.
+func (c Config) AllGroups() []NodeGroup {
+	var ngs []NodeGroup
+	if len(c.Nodes) != 0 {
+		ngs = append(ngs, NodeGroup{Name: DefaultGroup, Nodes: c.Nodes})
+	}
+	names := make([]string, 0, len(c.Groups))
+	for name := range c.Groups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		ng := c.Groups[name]
+		ng.Name = name
+		ngs = append(ngs, ng)
+	}
+	return ngs
 }
 
+// Node describes a single build node. Its fields mirror the buildx
+// store.Node, but are spelled out to keep the file format independent of it.
 type Node struct {
-	store.Node
-	Driver string
+	Name       string            `json:"name"`
+	Driver     string            `json:"driver,omitempty"`
+	Endpoint   string            `json:"endpoint,omitempty"`
+	Platforms  Platforms         `json:"platforms,omitempty"`
+	Flags      []string          `json:"flags,omitempty"`
+	DriverOpts map[string]string `json:"driverOpts,omitempty"`
+	Files      map[string][]byte `json:"files,omitempty"`
+	// BuildkitConfig is the path of a buildkitd.toml, the files it
+	// references are loaded into Files
+	BuildkitConfig string `json:"buildkitConfig,omitempty"`
+	// Labels are matched by node selectors
+	Labels map[string]string `json:"labels,omitempty"`
+	// TLS enables tls for the connection to the endpoint
+	TLS *TLS `json:"tls,omitempty"`
+	// SSH configures ssh:// endpoints
+	SSH *SSH `json:"ssh,omitempty"`
+	// Kubernetes configures the cluster of the kubernetes driver
+	Kubernetes *Kubernetes `json:"kubernetes,omitempty"`
+	// ConnectTimeout limits each attempt to connect to the node
+	ConnectTimeout Duration `json:"connectTimeout,omitempty"`
+	// Retries is the number of times a failed connection is retried
+	Retries *int `json:"retries,omitempty"`
+
+	// Sources lists the files that declared the node, in merge order
+	Sources []string `json:"-"`
+
+	pos map[string]Position
+}
+
+// Pos returns the position field was declared at, or the position of the
+// node itself if it is unknown.
+func (n Node) Pos(field string) Position {
+	if p, ok := n.pos[strings.ToLower(field)]; ok {
+		return p
+	}
+	return n.pos[""]
+}
+
+// TLS configures the connection to a docker endpoint. Without Cert and Key
+// only the server is verified.
+type TLS struct {
+	CA   string `json:"ca,omitempty"`
+	Cert string `json:"cert,omitempty"`
+	Key  string `json:"key,omitempty"`
+	// Verify checks the server certificate, it defaults to true
+	Verify *bool `json:"verify,omitempty"`
+	// ServerName overrides the name the server certificate is checked for
+	ServerName string `json:"serverName,omitempty"`
+}
+
+// SkipVerify reports whether the server certificate is not checked.
+func (t TLS) SkipVerify() bool {
+	return t.Verify != nil && !*t.Verify
+}
+
+// SSH configures the connection to ssh:// endpoints, which tunnel the docker
+// API through `docker system dial-stdio` on the remote host.
+type SSH struct {
+	IdentityFile string `json:"identityFile,omitempty"`
+	// Port is used if the endpoint does not include one
+	Port int `json:"port,omitempty"`
+	// KnownHosts is the known_hosts file the host key is checked against
+	KnownHosts string `json:"knownHosts,omitempty"`
+	// HostKeyCheck is the ssh StrictHostKeyChecking option: yes, no or
+	// accept-new
+	HostKeyCheck string `json:"hostKeyCheck,omitempty"`
+}
+
+// Kubernetes selects the cluster and namespace the kubernetes driver deploys
+// buildkit to. The kubeconfig is loaded like kubectl does if it is not set.
+type Kubernetes struct {
+	Kubeconfig string `json:"kubeconfig,omitempty"`
+	// Context defaults to the current context of the kubeconfig
+	Context string `json:"context,omitempty"`
+	// Namespace defaults to the namespace of the context
+	Namespace string `json:"namespace,omitempty"`
+	// Replicas is the number of buildkit pods
+	Replicas int `json:"replicas,omitempty"`
+}
+
+// Duration is written as a string like "10s".
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// Platforms are written as "os/arch[/variant]" strings.
+type Platforms []specs.Platform
+
+func (ps Platforms) MarshalJSON() ([]byte, error) {
+	ss := make([]string, len(ps))
+	for i, p := range ps {
+		ss[i] = platforms.Format(p)
+	}
+	return json.Marshal(ss)
+}
+
+func (ps *Platforms) UnmarshalJSON(b []byte) error {
+	var ss []string
+	if err := json.Unmarshal(b, &ss); err != nil {
+		return err
+	}
+	*ps = make(Platforms, len(ss))
+	for i, s := range ss {
+		p, err := platforms.Parse(s)
+		if err != nil {
+			return err
+		}
+		(*ps)[i] = p
+	}
+	return nil
 }
 
 func load(dir string) (fp string) {
@@ -50,82 +242,218 @@ func load(dir string) (fp string) {
 	if _, err := os.Stat(fp); err == nil {
 		return fp
 	}
+	fp = filepath.Join(dir, "asm.hcl")
+	if _, err := os.Stat(fp); err == nil {
+		return fp
+	}
 	return ""
 }
 
-func Load(fn string) (cfg NodeGroup, err error) {
-	if fn != "" {
-		goto parseAndExit
+// projectDirs returns the directories between the repository root containing
+// dir and dir itself, outermost first. Outside of a repository only dir is
+// returned.
+func projectDirs(dir string) []string {
+	dirs := []string{dir}
+	for cur := dir; ; {
+		if _, err := os.Stat(filepath.Join(cur, ".git")); err == nil {
+			break
+		}
+		parent := filepath.Dir(cur)
+		if parent == cur {
+			return []string{dir}
+		}
+		cur = parent
+		dirs = append([]string{cur}, dirs...)
 	}
+	return dirs
+}
 
-	// try local dir
-	if cwd, err := os.Getwd(); err == nil {
-		if fn = load(cwd); fn != "" {
-			goto parseAndExit
+// SystemDirs returns the system wide config directories from
+// XDG_CONFIG_DIRS, least important first.
+func SystemDirs() []string {
+	env := os.Getenv("XDG_CONFIG_DIRS")
+	if env == "" {
+		if runtime.GOOS == "windows" {
+			return nil
+		}
+		env = "/etc/xdg"
+	}
+	// XDG_CONFIG_DIRS lists the most important directory first
+	var dirs []string
+	for _, dir := range filepath.SplitList(env) {
+		if filepath.IsAbs(dir) {
+			dirs = append([]string{filepath.Join(dir, "asm")}, dirs...)
+		}
+	}
+	return dirs
+}
+
+// Files returns the config files that make up the configuration, in the
+// order they are merged: the system configs in XDG_CONFIG_DIRS, the user
+// config, the project configs from the repository root down to the working
+// directory and finally any explicitly requested files.
+func Files(explicit []string) (fns []string, err error) {
+	// try system configs
+	for _, dir := range SystemDirs() {
+		if fn := load(dir); fn != "" {
+			fns = append(fns, fn)
 		}
 	}
 
 	// try user config
-	if dir, err := ConfigDir(); err != nil {
-		return cfg, err
-	} else {
-		if fn = load(dir); fn != "" {
-			goto parseAndExit
+	dir, err := ConfigDir()
+	if err != nil {
+		return nil, err
+	}
+	if fn := load(dir); fn != "" {
+		fns = append(fns, fn)
+	}
+
+	// try project dirs
+	if cwd, err := os.Getwd(); err == nil {
+		for _, dir := range projectDirs(cwd) {
+			if fn := load(dir); fn != "" {
+				fns = append(fns, fn)
+			}
 		}
 	}
 
-	err = errors.New("no config file found")
-	return
+	fns = append(fns, explicit...)
 
-parseAndExit:
-	fn, err = filepath.Abs(fn)
+	for i, fn := range fns {
+		if fns[i], err = filepath.Abs(fn); err != nil {
+			return nil, err
+		}
+	}
+
+	// a file given more than once is merged at its last position
+	seen := make(map[string]bool, len(fns))
+	for i := len(fns) - 1; i >= 0; i-- {
+		if seen[fns[i]] {
+			fns = append(fns[:i], fns[i+1:]...)
+			continue
+		}
+		seen[fns[i]] = true
+	}
+	return fns, nil
+}
+
+// Load parses and merges all config files returned by Files and applies the
+// profile, if any.
+func Load(explicit []string, profile string) (cfg Config, err error) {
+	fns, err := Files(explicit)
 	if err != nil {
 		return cfg, err
 	}
+	if len(fns) == 0 {
+		return cfg, errors.New("no config file found")
+	}
 
-	logrus.WithField("path", fn).Debug("loading configuration")
-	return Parse(fn)
+	for _, fn := range fns {
+		logrus.WithField("path", fn).Debug("loading configuration")
+		layer, err := Parse(fn)
+		if err != nil {
+			return cfg, err
+		}
+		cfg.Merge(layer)
+	}
+	return cfg.load(profile)
 }
 
-func Parse(fn string) (cfg NodeGroup, err error) {
-	var b []byte
-	b, err = ioutil.ReadFile(fn)
+// LoadWith is like Load, but uses b as the contents of the config file fn.
+// If fn is not one of the discovered files it is loaded as the user config.
+func LoadWith(explicit []string, profile, fn string, b []byte) (cfg Config, err error) {
+	fns, err := Files(explicit)
 	if err != nil {
 		return cfg, err
 	}
-	switch filepath.Ext(fn) {
-	case ".yaml", ".yml":
-		b, err = yaml.YAMLToJSONStrict(b)
-		if err != nil {
-			return cfg, fmt.Errorf("error parsing yaml: %w", err)
+	found := false
+	for _, f := range fns {
+		found = found || f == fn
+	}
+	if !found {
+		fns = append([]string{fn}, fns...)
+	}
+
+	for _, f := range fns {
+		var layer Config
+		if f == fn {
+			layer, err = parse(f, b)
+		} else {
+			layer, err = Parse(f)
 		}
-		fallthrough
-	case ".json":
-		err = json.Unmarshal(b, &cfg)
 		if err != nil {
-			return cfg, fmt.Errorf("error parsing json: %w", err)
+			return cfg, err
 		}
-	default:
-		err = errors.Errorf("format not supported: %s", filepath.Ext(fn))
+		cfg.Merge(layer)
 	}
-	return cfg, err
+	return cfg.load(profile)
 }
 
-func Write(w io.Writer, cfg NodeGroup, format string) (err error) {
+// load finishes loading the merged config.
+func (c Config) load(profile string) (Config, error) {
+	if profile != "" {
+		var err error
+		if c, err = c.WithProfile(profile); err != nil {
+			return c, err
+		}
+	}
+	return c, c.Validate()
+}
+
+// ActiveFile returns the config file that changes are written to, the most
+// specific one returned by Files. Without any config file it is the user
+// config, which may not exist yet.
+func ActiveFile(explicit []string) (string, error) {
+	fns, err := Files(explicit)
+	if err != nil {
+		return "", err
+	}
+	if len(fns) != 0 {
+		return fns[len(fns)-1], nil
+	}
+	dir, err := ConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "asm.yml"), nil
+}
+
+// Write encodes cfg in the newest schema version.
+func Write(w io.Writer, cfg Config, format string) (err error) {
+	cfg.Version = Version
 	switch format {
 	case "yaml", "yml":
-		var b []byte
-		b, err = yaml.Marshal(cfg)
-		if err != nil {
+		// go through json to keep the field order and names
+		var (
+			b   []byte
+			doc yaml.Node
+		)
+		if b, err = json.Marshal(cfg); err != nil {
+			return
+		}
+		if err = yaml.Unmarshal(b, &doc); err != nil {
 			return
 		}
-		_, err = w.Write(b)
+		blockStyle(&doc)
+		enc := yaml.NewEncoder(w)
+		enc.SetIndent(2)
+		err = enc.Encode(&doc)
 	case "json":
 		enc := json.NewEncoder(w)
 		enc.SetIndent("", "  ")
 		err = enc.Encode(cfg)
+	case "hcl":
+		err = writeHCL(w, cfg)
 	default:
 		err = errors.Errorf("format not supported: %s", format)
 	}
 	return
 }
+
+func blockStyle(n *yaml.Node) {
+	n.Style = 0
+	for _, c := range n.Content {
+		blockStyle(c)
+	}
+}
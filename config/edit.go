@@ -0,0 +1,400 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// File is a yaml or json config file opened for editing. Edits keep the
+// comments and the order of the existing document.
+type File struct {
+	Path string
+	// From is the schema version the file was written in, edited files are
+	// migrated to the newest version
+	From int
+
+	doc yaml.Node
+}
+
+// OpenFile reads the config file fn for editing. A file that does not exist
+// yet is opened empty.
+func OpenFile(fn string) (*File, error) {
+	switch filepath.Ext(fn) {
+	case ".yaml", ".yml", ".json":
+	case ".hcl":
+		return nil, errors.Errorf("%s: editing hcl configs is not supported", fn)
+	default:
+		return nil, errors.Errorf("%s: format not supported: %s", fn, filepath.Ext(fn))
+	}
+
+	f := &File{Path: fn}
+	b, err := ioutil.ReadFile(fn)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	if filepath.Ext(fn) != ".json" {
+		b = markBlank(b)
+	}
+	if err := yaml.Unmarshal(b, &f.doc); err != nil {
+		return nil, errors.Wrap(err, fn)
+	}
+	if len(f.doc.Content) == 0 {
+		f.From = Version
+		f.doc = yaml.Node{
+			Kind: yaml.DocumentNode,
+			Content: []*yaml.Node{{
+				Kind: yaml.MappingNode,
+				Tag:  "!!map",
+				Content: []*yaml.Node{
+					{Kind: yaml.ScalarNode, Tag: "!!str", Value: "version"},
+					{Kind: yaml.ScalarNode, Tag: "!!int", Value: strconv.Itoa(Version)},
+				},
+			}},
+		}
+		return f, nil
+	}
+	if f.From, err = migrate(&f.doc); err != nil {
+		return nil, errors.Wrap(err, fn)
+	}
+	return f, nil
+}
+
+// Bytes encodes the edited file in its format.
+func (f *File) Bytes() ([]byte, error) {
+	return encode(&f.doc, filepath.Ext(f.Path))
+}
+
+// AddNode appends n to the node group called group, creating the group if
+// needed. An empty group is the default group of the file.
+func (f *File) AddNode(group string, n Node) error {
+	list, err := f.nodeList(group, true)
+	if err != nil {
+		return err
+	}
+	if findNode(list, n.Name) >= 0 {
+		return errors.Errorf("node %q already exists in %s", n.Name, f.Path)
+	}
+	v, err := toYAML(n)
+	if err != nil {
+		return err
+	}
+	appendNode(list, v)
+	return nil
+}
+
+// RemoveNode removes the node called name from group.
+func (f *File) RemoveNode(group, name string) error {
+	list, err := f.nodeList(group, false)
+	if err != nil {
+		return err
+	}
+	i := findNode(list, name)
+	if i < 0 {
+		return errors.Errorf("node %q is not declared in %s", name, f.Path)
+	}
+	list.Content = append(list.Content[:i], list.Content[i+1:]...)
+	if i == 0 && len(list.Content) != 0 {
+		// the list does not start with a blank line
+		first := list.Content[0]
+		first.HeadComment = strings.TrimPrefix(strings.TrimPrefix(first.HeadComment, blankMarker), "\n")
+	}
+	return nil
+}
+
+// SetNode sets the non-empty fields of patch on the node of the same name
+// and then removes the unset fields, given as "field" or "field.key". A node
+// declared in another file is overridden by adding it to this one.
+func (f *File) SetNode(group string, patch Node, unset []string) error {
+	list, err := f.nodeList(group, true)
+	if err != nil {
+		return err
+	}
+	var n *yaml.Node
+	if i := findNode(list, patch.Name); i >= 0 {
+		n = list.Content[i]
+	} else {
+		n = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+		appendNode(list, n)
+	}
+
+	v, err := toYAML(patch)
+	if err != nil {
+		return err
+	}
+	mergeMapping(n, v)
+
+	for _, field := range unset {
+		parts := strings.SplitN(field, ".", 2)
+		_, v := mappingValue(n, parts[0])
+		if v == nil || strings.EqualFold(parts[0], "name") {
+			return errors.Errorf("field %q of node %q is not set", field, patch.Name)
+		}
+		if len(parts) == 1 {
+			removeKey(n, parts[0])
+			continue
+		}
+		if k, _ := lookup(v, parts[1]); k == nil {
+			return errors.Errorf("field %q of node %q is not set", field, patch.Name)
+		}
+		removeKey(v, parts[1])
+		if len(v.Content) == 0 {
+			removeKey(n, parts[0])
+		}
+	}
+	return nil
+}
+
+// nodeList returns the sequence of nodes of group, optionally creating it.
+func (f *File) nodeList(group string, create bool) (*yaml.Node, error) {
+	root := f.doc.Content[0]
+	if group == "" {
+		if _, v := lookup(root, "default"); v != nil {
+			group = v.Value
+		}
+	}
+
+	notFound := func() (*yaml.Node, error) {
+		if group == "" {
+			group = DefaultGroup
+		}
+		return nil, errors.Errorf("group %q is not declared in %s", group, f.Path)
+	}
+	child := func(m *yaml.Node, key string, kind yaml.Kind, tag string) *yaml.Node {
+		if _, v := lookup(m, key); v != nil || !create {
+			return v
+		}
+		v := &yaml.Node{Kind: kind, Tag: tag}
+		m.Style = 0
+		m.Content = append(m.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key}, v)
+		return v
+	}
+
+	parent := root
+	_, groups := lookup(root, "groups")
+	if _, ng := lookup(groups, DefaultGroup); (group != "" && group != DefaultGroup) || ng != nil {
+		if group == "" {
+			group = DefaultGroup
+		}
+		if groups = child(root, "groups", yaml.MappingNode, "!!map"); groups == nil {
+			return notFound()
+		}
+		if parent = child(groups, group, yaml.MappingNode, "!!map"); parent == nil {
+			return notFound()
+		}
+	}
+	list := child(parent, "nodes", yaml.SequenceNode, "!!seq")
+	if list == nil {
+		return notFound()
+	}
+	if list.Kind != yaml.SequenceNode {
+		return nil, errors.Errorf("%s:%d:%d: nodes must be a list", f.Path, list.Line, list.Column)
+	}
+	return list, nil
+}
+
+// appendNode appends n to list, separating it by a blank line like the
+// nodes already in list are.
+func appendNode(list, n *yaml.Node) {
+	for i, item := range list.Content {
+		if i > 0 && strings.HasPrefix(item.HeadComment, blankMarker) {
+			n.HeadComment = blankMarker
+			break
+		}
+	}
+	list.Style = 0
+	list.Content = append(list.Content, n)
+}
+
+// findNode returns the index of the node called name in list, or -1.
+func findNode(list *yaml.Node, name string) int {
+	for i, n := range list.Content {
+		if _, v := lookup(n, "name"); v != nil && v.Value == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// lookup returns the key and value nodes of key in the mapping m.
+func lookup(m *yaml.Node, key string) (*yaml.Node, *yaml.Node) {
+	if m == nil || m.Kind != yaml.MappingNode {
+		return nil, nil
+	}
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		if m.Content[i].Value == key {
+			return m.Content[i], m.Content[i+1]
+		}
+	}
+	return nil, nil
+}
+
+// mergeMapping sets the keys of src on dst, merging nested mappings and
+// keeping the comments of replaced values.
+func mergeMapping(dst, src *yaml.Node) {
+	for i := 0; i+1 < len(src.Content); i += 2 {
+		k, v := src.Content[i], src.Content[i+1]
+		_, old := lookup(dst, k.Value)
+		switch {
+		case old == nil:
+			dst.Style = 0
+			dst.Content = append(dst.Content, k, v)
+		case old.Kind == yaml.MappingNode && v.Kind == yaml.MappingNode:
+			mergeMapping(old, v)
+		default:
+			v.HeadComment, v.LineComment, v.FootComment = old.HeadComment, old.LineComment, old.FootComment
+			*old = *v
+		}
+	}
+}
+
+// toYAML converts v to a yaml node through its json encoding.
+func toYAML(v interface{}) (*yaml.Node, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(b, &doc); err != nil {
+		return nil, err
+	}
+	blockStyle(&doc)
+	return doc.Content[0], nil
+}
+
+// encode writes doc as yaml or, for the ".json" extension, as json.
+func encode(doc *yaml.Node, ext string) ([]byte, error) {
+	var buf bytes.Buffer
+	if ext != ".json" {
+		enc := yaml.NewEncoder(&buf)
+		enc.SetIndent(2)
+		err := enc.Encode(doc)
+		return unmarkBlank(buf.Bytes()), err
+	}
+
+	if err := encodeJSON(&buf, doc); err != nil {
+		return nil, err
+	}
+	var out bytes.Buffer
+	if err := json.Indent(&out, buf.Bytes(), "", "  "); err != nil {
+		return nil, err
+	}
+	out.WriteByte('\n')
+	return out.Bytes(), nil
+}
+
+// blankMarker is the comment standing in for blank lines while a yaml
+// document is edited, which the yaml encoder would drop otherwise.
+const blankMarker = "#asm:blank"
+
+// markBlank replaces the blank lines separating the values of a yaml
+// document by blankMarker comments. Blank lines before the first value are
+// kept by the yaml package itself.
+func markBlank(b []byte) []byte {
+	lines := strings.Split(string(b), "\n")
+	out := make([]string, 0, len(lines))
+	var (
+		prev  string
+		value bool
+	)
+	for i := 0; i < len(lines); i++ {
+		if strings.TrimSpace(lines[i]) != "" {
+			out = append(out, lines[i])
+			prev = strings.TrimSpace(lines[i])
+			value = value || !strings.HasPrefix(prev, "#")
+			continue
+		}
+		j := i
+		for j < len(lines) && strings.TrimSpace(lines[j]) == "" {
+			j++
+		}
+		switch {
+		case !value || j == len(lines):
+			out = append(out, lines[i:j]...)
+		case strings.HasPrefix(prev, "#"):
+			// dropped, a marker here would move the comment to the
+			// previous value
+		default:
+			// indented like the next value to attach the marker to it
+			next := lines[j]
+			out = append(out, next[:len(next)-len(strings.TrimLeft(next, " "))]+blankMarker)
+		}
+		i = j - 1
+	}
+	return []byte(strings.Join(out, "\n"))
+}
+
+// unmarkBlank turns the blankMarker comments of b back into blank lines.
+func unmarkBlank(b []byte) []byte {
+	lines := strings.Split(string(b), "\n")
+	for i, l := range lines {
+		if strings.TrimSpace(l) == blankMarker {
+			lines[i] = ""
+		}
+	}
+	return []byte(strings.Join(lines, "\n"))
+}
+
+// encodeJSON writes n as json, keeping the order of mapping keys.
+func encodeJSON(buf *bytes.Buffer, n *yaml.Node) error {
+	switch n.Kind {
+	case yaml.DocumentNode:
+		if len(n.Content) == 0 {
+			buf.WriteString("null")
+			return nil
+		}
+		return encodeJSON(buf, n.Content[0])
+	case yaml.AliasNode:
+		return encodeJSON(buf, n.Alias)
+	case yaml.MappingNode:
+		buf.WriteByte('{')
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			k, err := json.Marshal(n.Content[i].Value)
+			if err != nil {
+				return err
+			}
+			buf.Write(k)
+			buf.WriteByte(':')
+			if err := encodeJSON(buf, n.Content[i+1]); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('}')
+		return nil
+	case yaml.SequenceNode:
+		buf.WriteByte('[')
+		for i, v := range n.Content {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if err := encodeJSON(buf, v); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte(']')
+		return nil
+	}
+
+	var v interface{} = n.Value
+	if n.ShortTag() != "!!timestamp" {
+		if err := n.Decode(&v); err != nil {
+			return err
+		}
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	buf.Write(b)
+	return nil
+}
@@ -0,0 +1,197 @@
+package config
+
+import (
+	"testing"
+)
+
+func TestEdit(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		file string
+		in   string
+		edit func(*File) error
+		want string
+	}{
+		{
+			name: "unchanged",
+			file: "asm.yml",
+			in: `# build nodes
+
+version: 2
+nodes:
+  # local builder
+  - name: a
+    driver: docker # the daemon
+    endpoint: unix:///var/run/docker.sock
+
+  - name: b
+    driver: docker
+    labels:
+      zone: eu
+
+      arch: arm64
+`,
+			edit: func(*File) error { return nil },
+			want: `# build nodes
+
+version: 2
+nodes:
+  # local builder
+  - name: a
+    driver: docker # the daemon
+    endpoint: unix:///var/run/docker.sock
+
+  - name: b
+    driver: docker
+    labels:
+      zone: eu
+
+      arch: arm64
+`,
+		},
+		{
+			name: "add without version",
+			file: "asm.yml",
+			in: `# build nodes
+nodes:
+  - name: a
+    driver: docker
+
+  # arm builder
+  - name: b
+    driver: docker
+`,
+			edit: func(f *File) error {
+				return f.AddNode("", Node{Name: "c", Driver: "docker", Endpoint: "tcp://c:2376"})
+			},
+			want: `# build nodes
+version: 2
+nodes:
+  - name: a
+    driver: docker
+
+  # arm builder
+  - name: b
+    driver: docker
+
+  - name: c
+    driver: docker
+    endpoint: tcp://c:2376
+`,
+		},
+		{
+			name: "add to a group",
+			file: "asm.yml",
+			in: `version: 2
+nodes:
+  - name: a
+    driver: docker
+`,
+			edit: func(f *File) error {
+				return f.AddNode("arm", Node{Name: "b", Driver: "docker"})
+			},
+			want: `version: 2
+nodes:
+  - name: a
+    driver: docker
+groups:
+  arm:
+    nodes:
+      - name: b
+        driver: docker
+`,
+		},
+		{
+			name: "remove the first node",
+			file: "asm.yml",
+			in: `version: 2
+nodes:
+  - name: a
+    driver: docker
+
+  - name: b
+    driver: docker # kept
+
+  - name: c
+    driver: docker
+`,
+			edit: func(f *File) error { return f.RemoveNode("", "a") },
+			want: `version: 2
+nodes:
+  - name: b
+    driver: docker # kept
+
+  - name: c
+    driver: docker
+`,
+		},
+		{
+			name: "set and unset",
+			file: "asm.yml",
+			in: `version: 2
+nodes:
+  - name: a
+    driver: docker # the daemon
+    labels:
+      zone: eu # europe
+      arch: amd64
+`,
+			edit: func(f *File) error {
+				return f.SetNode("", Node{Name: "a", Driver: "docker-container", Labels: map[string]string{"zone": "us"}}, []string{"labels.arch"})
+			},
+			want: `version: 2
+nodes:
+  - name: a
+    driver: docker-container # the daemon
+    labels:
+      zone: us # europe
+`,
+		},
+		{
+			name: "json",
+			file: "asm.json",
+			in: `{
+  "version": 2,
+
+  "nodes": [{"name": "a", "driver": "docker"}]
+}
+`,
+			edit: func(f *File) error { return f.AddNode("", Node{Name: "b", Driver: "docker"}) },
+			want: `{
+  "version": 2,
+  "nodes": [
+    {
+      "name": "a",
+      "driver": "docker"
+    },
+    {
+      "name": "b",
+      "driver": "docker"
+    }
+  ]
+}
+`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fn := writeConfig(t, t.TempDir(), tc.file, tc.in)
+			f, err := OpenFile(fn)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := tc.edit(f); err != nil {
+				t.Fatal(err)
+			}
+			b, err := f.Bytes()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(b) != tc.want {
+				t.Errorf("expected\n%s\ngot\n%s", tc.want, b)
+			}
+			if _, err := parse(fn, b); err != nil {
+				t.Errorf("edited file does not parse: %s", err)
+			}
+		})
+	}
+}
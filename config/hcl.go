@@ -0,0 +1,428 @@
+package config
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/containerd/containerd/platforms"
+	"github.com/docker/buildx/bake/hclparser"
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclparse"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/hashicorp/hcl/v2/hclwrite"
+	"github.com/zclconf/go-cty/cty"
+)
+
+// hclConfig is the hcl representation of Config. Nodes outside of a group
+// block belong to the default group. Variables and functions are supported
+// the same way bake does.
+type hclConfig struct {
+	Nodes    []*hclNode    `hcl:"node,block"`
+	Groups   []*hclGroup   `hcl:"group,block"`
+	Profiles []*hclProfile `hcl:"profile,block"`
+}
+
+type hclProfile struct {
+	Name  string     `hcl:"name,label"`
+	Nodes []*hclNode `hcl:"node,block"`
+}
+
+type hclGroup struct {
+	Name    string     `hcl:"name,label"`
+	Default bool       `hcl:"default,optional"`
+	Nodes   []*hclNode `hcl:"node,block"`
+	Body    hcl.Body   `hcl:",body"`
+}
+
+type hclNode struct {
+	Name       string            `hcl:"name,label"`
+	Driver     string            `hcl:"driver,optional"`
+	Endpoint   string            `hcl:"endpoint,optional"`
+	Platforms  []string          `hcl:"platforms,optional"`
+	Flags      []string          `hcl:"flags,optional"`
+	DriverOpts map[string]string `hcl:"driver-opts,optional"`
+	Labels     map[string]string `hcl:"labels,optional"`
+	// BuildkitConfig is the path of a buildkitd.toml
+	BuildkitConfig string         `hcl:"buildkit-config,optional"`
+	TLS            *hclTLS        `hcl:"tls,block"`
+	SSH            *hclSSH        `hcl:"ssh,block"`
+	Kubernetes     *hclKubernetes `hcl:"kubernetes,block"`
+	ConnectTimeout string         `hcl:"connect-timeout,optional"`
+	Retries        *int           `hcl:"retries,optional"`
+	// Unset is only allowed in profiles
+	Unset []string `hcl:"unset,optional"`
+	Body  hcl.Body `hcl:",body"`
+}
+
+type hclTLS struct {
+	CA         string   `hcl:"ca,optional"`
+	Cert       string   `hcl:"cert,optional"`
+	Key        string   `hcl:"key,optional"`
+	Verify     *bool    `hcl:"verify,optional"`
+	ServerName string   `hcl:"server-name,optional"`
+	Body       hcl.Body `hcl:",body"`
+}
+
+type hclSSH struct {
+	IdentityFile string   `hcl:"identity-file,optional"`
+	Port         int      `hcl:"port,optional"`
+	KnownHosts   string   `hcl:"known-hosts,optional"`
+	HostKeyCheck string   `hcl:"host-key-check,optional"`
+	Body         hcl.Body `hcl:",body"`
+}
+
+type hclKubernetes struct {
+	Kubeconfig string   `hcl:"kubeconfig,optional"`
+	Context    string   `hcl:"context,optional"`
+	Namespace  string   `hcl:"namespace,optional"`
+	Replicas   int      `hcl:"replicas,optional"`
+	Body       hcl.Body `hcl:",body"`
+}
+
+// hcl attribute names that differ from the field names used for positions
+var hclFields = map[string]string{
+	"driver-opts":     "driveropts",
+	"buildkit-config": "buildkitconfig",
+	"server-name":     "servername",
+	"connect-timeout": "connecttimeout",
+	"identity-file":   "identityfile",
+	"known-hosts":     "knownhosts",
+	"host-key-check":  "hostkeycheck",
+}
+
+func parseHCL(fn string, b []byte) (cfg Config, err error) {
+	f, diags := hclparse.NewParser().ParseHCL(b, fn)
+	if diags.HasErrors() {
+		return cfg, hclErrors(diags)
+	}
+
+	var c hclConfig
+	if diags := hclparser.Parse(f.Body, hclparser.Opt{LookupVar: os.LookupEnv}, &c); diags.HasErrors() {
+		return cfg, hclErrors(diags)
+	}
+
+	// blocks are reported as errors here, but the attributes are returned
+	// regardless
+	cfg.Version = Version
+	attrs, _ := f.Body.JustAttributes()
+	if attr, ok := attrs["version"]; ok {
+		v, diags := attr.Expr.Value(nil)
+		if diags.HasErrors() {
+			return cfg, hclErrors(diags)
+		}
+		if v.Type() != cty.Number || v.IsNull() {
+			return cfg, &ValidationError{Pos: hclPos(attr.Range), Msg: "version must be a number"}
+		}
+		if _, err := checkVersion(v.AsBigFloat().Text('f', -1)); err != nil {
+			return cfg, &ValidationError{Pos: hclPos(attr.Range), Msg: err.Error()}
+		}
+	}
+
+	for _, g := range c.Groups {
+		nodes, err := g.nodes(fn)
+		if err != nil {
+			return cfg, err
+		}
+		if g.Default {
+			cfg.Default = g.Name
+			cfg.defaultPos = hclPos(g.Body.MissingItemRange())
+		}
+		if g.Name == DefaultGroup {
+			cfg.Nodes = append(cfg.Nodes, nodes...)
+			continue
+		}
+		if cfg.Groups == nil {
+			cfg.Groups = make(map[string]NodeGroup)
+		}
+		cfg.Groups[g.Name] = NodeGroup{Nodes: nodes}
+	}
+	nodes, err := (&hclGroup{Nodes: c.Nodes}).nodes(fn)
+	if err != nil {
+		return cfg, err
+	}
+	cfg.Nodes = append(nodes, cfg.Nodes...)
+
+	for _, p := range c.Profiles {
+		if cfg.Profiles == nil {
+			cfg.Profiles = make(map[string]Profile)
+		}
+		nodes, err := hclNodes(fn, p.Nodes, true)
+		if err != nil {
+			return cfg, err
+		}
+		profile := cfg.Profiles[p.Name]
+		for i, n := range nodes {
+			profile.Nodes = append(profile.Nodes, ProfileNode{Node: n, Unset: p.Nodes[i].Unset})
+		}
+		cfg.Profiles[p.Name] = profile
+	}
+	return cfg, nil
+}
+
+func (g *hclGroup) nodes(fn string) ([]Node, error) {
+	return hclNodes(fn, g.Nodes, false)
+}
+
+func hclNodes(fn string, hns []*hclNode, profile bool) ([]Node, error) {
+	nodes := make([]Node, 0, len(hns))
+	for _, hn := range hns {
+		n := Node{
+			Driver:  hn.Driver,
+			Sources: []string{fn},
+			pos:     make(map[string]Position),
+		}
+		n.Name = hn.Name
+		n.Endpoint = hn.Endpoint
+		n.Flags = hn.Flags
+		n.DriverOpts = hn.DriverOpts
+		n.Labels = hn.Labels
+		n.BuildkitConfig = hn.BuildkitConfig
+		if t := hn.TLS; t != nil {
+			n.TLS = &TLS{CA: t.CA, Cert: t.Cert, Key: t.Key, Verify: t.Verify, ServerName: t.ServerName}
+		}
+		if s := hn.SSH; s != nil {
+			n.SSH = &SSH{IdentityFile: s.IdentityFile, Port: s.Port, KnownHosts: s.KnownHosts, HostKeyCheck: s.HostKeyCheck}
+		}
+		if k := hn.Kubernetes; k != nil {
+			n.Kubernetes = &Kubernetes{Kubeconfig: k.Kubeconfig, Context: k.Context, Namespace: k.Namespace, Replicas: k.Replicas}
+		}
+
+		if body, ok := hn.Body.(*hclsyntax.Body); ok {
+			n.pos[""] = hclPos(body.SrcRange)
+			n.pos["name"] = n.pos[""]
+			for name, attr := range body.Attributes {
+				n.pos[hclField(name)] = hclPos(attr.SrcRange)
+			}
+			if hn.TLS != nil {
+				if body, ok := hn.TLS.Body.(*hclsyntax.Body); ok {
+					n.pos["tls"] = hclPos(body.SrcRange)
+					for name, attr := range body.Attributes {
+						n.pos["tls."+hclField(name)] = hclPos(attr.SrcRange)
+					}
+				}
+			}
+			if hn.SSH != nil {
+				if body, ok := hn.SSH.Body.(*hclsyntax.Body); ok {
+					n.pos["ssh"] = hclPos(body.SrcRange)
+					for name, attr := range body.Attributes {
+						n.pos["ssh."+hclField(name)] = hclPos(attr.SrcRange)
+					}
+				}
+			}
+			if hn.Kubernetes != nil {
+				if body, ok := hn.Kubernetes.Body.(*hclsyntax.Body); ok {
+					n.pos["kubernetes"] = hclPos(body.SrcRange)
+					for name, attr := range body.Attributes {
+						n.pos["kubernetes."+hclField(name)] = hclPos(attr.SrcRange)
+					}
+				}
+			}
+			for k := range hn.DriverOpts {
+				n.pos["driveropts."+strings.ToLower(k)] = n.pos["driveropts"]
+			}
+			for k := range hn.Labels {
+				n.pos["labels."+strings.ToLower(k)] = n.pos["labels"]
+			}
+			for i := range hn.Unset {
+				n.pos[fmt.Sprintf("unset[%d]", i)] = n.pos["unset"]
+			}
+		}
+
+		for _, s := range hn.Platforms {
+			p, err := platforms.Parse(s)
+			if err != nil {
+				return nil, &ValidationError{Pos: n.Pos("platforms"), Msg: err.Error()}
+			}
+			n.Platforms = append(n.Platforms, p)
+		}
+		if hn.ConnectTimeout != "" {
+			d, err := time.ParseDuration(hn.ConnectTimeout)
+			if err != nil {
+				return nil, &ValidationError{Pos: n.Pos("connectTimeout"), Msg: err.Error()}
+			}
+			n.ConnectTimeout = Duration(d)
+		}
+		n.Retries = hn.Retries
+		if len(hn.Unset) != 0 && !profile {
+			return nil, &ValidationError{Pos: n.Pos("unset"), Msg: fmt.Sprintf("node %q: unset is only supported in profiles", n.Name)}
+		}
+		nodes = append(nodes, n)
+	}
+	return nodes, nil
+}
+
+// hclErrors converts the errors in diags, which carry their own positions.
+func hclErrors(diags hcl.Diagnostics) error {
+	var errs ValidationErrors
+	for _, d := range diags {
+		if d.Severity != hcl.DiagError {
+			continue
+		}
+		verr := &ValidationError{Msg: d.Summary}
+		if d.Detail != "" {
+			verr.Msg += ": " + d.Detail
+		}
+		if d.Subject != nil {
+			verr.Pos = hclPos(*d.Subject)
+		}
+		errs = append(errs, verr)
+	}
+	return errs
+}
+
+func hclField(name string) string {
+	if f, ok := hclFields[name]; ok {
+		return f
+	}
+	return name
+}
+
+func hclPos(r hcl.Range) Position {
+	return Position{File: r.Filename, Line: r.Start.Line, Column: r.Start.Column}
+}
+
+func writeHCL(w io.Writer, cfg Config) error {
+	f := hclwrite.NewEmptyFile()
+	root := f.Body()
+	root.SetAttributeValue("version", cty.NumberIntVal(int64(cfg.Version)))
+
+	for _, ng := range cfg.AllGroups() {
+		root.AppendNewline()
+		body := root
+		if ng.Name != DefaultGroup || len(cfg.Nodes) == 0 {
+			body = root.AppendNewBlock("group", []string{ng.Name}).Body()
+			if cfg.Default == ng.Name {
+				body.SetAttributeValue("default", cty.True)
+			}
+		}
+		for i, n := range ng.Nodes {
+			if i > 0 || body != root {
+				body.AppendNewline()
+			}
+			if err := writeHCLNode(body.AppendNewBlock("node", []string{n.Name}).Body(), n); err != nil {
+				return err
+			}
+		}
+	}
+
+	names := make([]string, 0, len(cfg.Profiles))
+	for name := range cfg.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		root.AppendNewline()
+		body := root.AppendNewBlock("profile", []string{name}).Body()
+		for i, pn := range cfg.Profiles[name].Nodes {
+			if i > 0 {
+				body.AppendNewline()
+			}
+			nb := body.AppendNewBlock("node", []string{pn.Name}).Body()
+			if err := writeHCLNode(nb, pn.Node); err != nil {
+				return err
+			}
+			if len(pn.Unset) != 0 {
+				nb.SetAttributeValue("unset", stringList(pn.Unset))
+			}
+		}
+	}
+	_, err := f.WriteTo(w)
+	return err
+}
+
+func writeHCLNode(body *hclwrite.Body, n Node) error {
+	if n.Driver != "" {
+		body.SetAttributeValue("driver", cty.StringVal(n.Driver))
+	}
+	if n.Endpoint != "" {
+		body.SetAttributeValue("endpoint", cty.StringVal(n.Endpoint))
+	}
+	if len(n.Platforms) != 0 {
+		ps := make([]cty.Value, len(n.Platforms))
+		for i, p := range n.Platforms {
+			ps[i] = cty.StringVal(platforms.Format(p))
+		}
+		body.SetAttributeValue("platforms", cty.ListVal(ps))
+	}
+	if len(n.Flags) != 0 {
+		body.SetAttributeValue("flags", stringList(n.Flags))
+	}
+	if n.BuildkitConfig != "" {
+		body.SetAttributeValue("buildkit-config", cty.StringVal(n.BuildkitConfig))
+	}
+	if len(n.DriverOpts) != 0 {
+		body.SetAttributeValue("driver-opts", stringMap(n.DriverOpts))
+	}
+	if n.ConnectTimeout != 0 {
+		body.SetAttributeValue("connect-timeout", cty.StringVal(time.Duration(n.ConnectTimeout).String()))
+	}
+	if n.Retries != nil {
+		body.SetAttributeValue("retries", cty.NumberIntVal(int64(*n.Retries)))
+	}
+	if len(n.Labels) != 0 {
+		body.SetAttributeValue("labels", stringMap(n.Labels))
+	}
+	if t := n.TLS; t != nil {
+		tls := body.AppendNewBlock("tls", nil).Body()
+		for _, attr := range []struct{ name, value string }{
+			{"ca", t.CA}, {"cert", t.Cert}, {"key", t.Key}, {"server-name", t.ServerName},
+		} {
+			if attr.value != "" {
+				tls.SetAttributeValue(attr.name, cty.StringVal(attr.value))
+			}
+		}
+		if t.Verify != nil {
+			tls.SetAttributeValue("verify", cty.BoolVal(*t.Verify))
+		}
+	}
+	if s := n.SSH; s != nil {
+		ssh := body.AppendNewBlock("ssh", nil).Body()
+		for _, attr := range []struct{ name, value string }{
+			{"identity-file", s.IdentityFile}, {"known-hosts", s.KnownHosts}, {"host-key-check", s.HostKeyCheck},
+		} {
+			if attr.value != "" {
+				ssh.SetAttributeValue(attr.name, cty.StringVal(attr.value))
+			}
+		}
+		if s.Port != 0 {
+			ssh.SetAttributeValue("port", cty.NumberIntVal(int64(s.Port)))
+		}
+	}
+	if k := n.Kubernetes; k != nil {
+		kube := body.AppendNewBlock("kubernetes", nil).Body()
+		for _, attr := range []struct{ name, value string }{
+			{"kubeconfig", k.Kubeconfig}, {"context", k.Context}, {"namespace", k.Namespace},
+		} {
+			if attr.value != "" {
+				kube.SetAttributeValue(attr.name, cty.StringVal(attr.value))
+			}
+		}
+		if k.Replicas != 0 {
+			kube.SetAttributeValue("replicas", cty.NumberIntVal(int64(k.Replicas)))
+		}
+	}
+	if len(n.Files) != 0 {
+		return fmt.Errorf("node %q: files are not supported in hcl", n.Name)
+	}
+	return nil
+}
+
+func stringList(ss []string) cty.Value {
+	vs := make([]cty.Value, len(ss))
+	for i, s := range ss {
+		vs[i] = cty.StringVal(s)
+	}
+	return cty.ListVal(vs)
+}
+
+func stringMap(m map[string]string) cty.Value {
+	vs := make(map[string]cty.Value, len(m))
+	for k, v := range m {
+		vs[k] = cty.StringVal(v)
+	}
+	return cty.MapVal(vs)
+}
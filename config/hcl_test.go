@@ -0,0 +1,34 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestConfigVersion(t *testing.T) {
+	for _, tc := range []struct {
+		version string
+		err     string
+	}{
+		{"0", ""},
+		{"2", ""},
+		{"3", "config version 3 is not supported, the newest version is 2"},
+		{"-3", `invalid config version "-3"`},
+		{"1.5", `invalid config version "1.5"`},
+	} {
+		for _, f := range []struct {
+			fn, b string
+		}{
+			{"/asm.yml", "version: " + tc.version + "\n"},
+			{"/asm.hcl", "version = " + tc.version + "\n"},
+		} {
+			_, err := parse(f.fn, []byte(f.b))
+			switch {
+			case tc.err == "" && err != nil:
+				t.Errorf("%s: version %s: unexpected error: %s", f.fn, tc.version, err)
+			case tc.err != "" && (err == nil || !strings.Contains(err.Error(), tc.err)):
+				t.Errorf("%s: version %s: expected error %q, got %v", f.fn, tc.version, tc.err, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// interpolate expands environment variables in every string value of v,
+// which is expected to be the result of unmarshalling json into an
+// interface{}. Supported are `$VAR`, `${VAR}`, `${VAR:-default}`,
+// `${VAR-default}`, `${VAR:?error}` and `${VAR?error}`, `$$` escapes a
+// literal dollar sign. t is the type v is decoded into, expanded values of
+// boolean and numeric fields are resolved like yaml scalars again. Failed
+// expansions are reported in errs.
+func interpolate(v interface{}, t reflect.Type, field string, pos map[string]Position, errs *ValidationErrors) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			f := k
+			if field != "" {
+				f = field + "." + k
+			}
+			v[k] = interpolate(v[k], fieldType(t, k), f, pos, errs)
+		}
+	case []interface{}:
+		for i := range v {
+			v[i] = interpolate(v[i], elemType(t), fmt.Sprintf("%s[%d]", field, i), pos, errs)
+		}
+	case string:
+		if !strings.Contains(v, "$") {
+			return v
+		}
+		s, err := expand(v, field)
+		if err != nil {
+			*errs = append(*errs, &ValidationError{Pos: pos[strings.ToLower(field)], Msg: err.Error()})
+			return nil
+		}
+		return resolve(s, t)
+	}
+	return v
+}
+
+var jsonUnmarshaler = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+
+// resolve returns the bool or number s stands for if t is a boolean or
+// numeric type, like `retries: ${RETRIES:-3}` would be read without the
+// variable. Otherwise, or if s is no such value, s is returned unchanged.
+func resolve(s string, t reflect.Type) interface{} {
+	t = indirect(t)
+	if t == nil || reflect.PtrTo(t).Implements(jsonUnmarshaler) {
+		return s
+	}
+	var v interface{}
+	if err := (&yaml.Node{Kind: yaml.ScalarNode, Value: s}).Decode(&v); err != nil {
+		return s
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		if _, ok := v.(bool); ok {
+			return v
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		switch v.(type) {
+		case int, int64, uint64, float64:
+			return v
+		}
+	}
+	return s
+}
+
+// fieldType returns the type the value of key decodes into when it is part
+// of a value of type t, nil if it is not known.
+func fieldType(t reflect.Type, key string) reflect.Type {
+	t = indirect(t)
+	if t == nil {
+		return nil
+	}
+	switch t.Kind() {
+	case reflect.Map:
+		return t.Elem()
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			tag := strings.Split(f.Tag.Get("json"), ",")[0]
+			if f.Anonymous && tag == "" {
+				if ft := fieldType(f.Type, key); ft != nil {
+					return ft
+				}
+				continue
+			}
+			if tag != "" && tag != "-" && strings.EqualFold(tag, key) {
+				return f.Type
+			}
+		}
+	}
+	return nil
+}
+
+// elemType returns the type of the elements of a list of type t, nil if it
+// is not known.
+func elemType(t reflect.Type) reflect.Type {
+	t = indirect(t)
+	if t == nil || (t.Kind() != reflect.Slice && t.Kind() != reflect.Array) {
+		return nil
+	}
+	return t.Elem()
+}
+
+func indirect(t reflect.Type) reflect.Type {
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t
+}
+
+func expand(s, field string) (string, error) {
+	var err error
+	s = os.Expand(s, func(name string) string {
+		if name == "$" {
+			return "$"
+		}
+		i := strings.IndexAny(name, ":-?")
+		if i == -1 {
+			return os.Getenv(name)
+		}
+		op := name[i : i+1]
+		if op == ":" && i+1 < len(name) {
+			op = name[i : i+2]
+		}
+		name, arg := name[:i], name[i+len(op):]
+		value, ok := os.LookupEnv(name)
+		if strings.HasPrefix(op, ":") {
+			ok = ok && value != ""
+		}
+		switch {
+		case ok:
+			return value
+		case strings.HasSuffix(op, "-"):
+			return arg
+		case strings.HasSuffix(op, "?"):
+			if arg == "" {
+				arg = "not set"
+			}
+			if err == nil {
+				err = errors.Errorf("%s: required variable %s: %s", field, name, arg)
+			}
+			return ""
+		}
+		if err == nil {
+			err = errors.Errorf("%s: invalid variable expansion: %s", field, name)
+		}
+		return ""
+	})
+	return s, err
+}
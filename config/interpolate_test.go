@@ -0,0 +1,110 @@
+package config
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestInterpolate(t *testing.T) {
+	setenv(t, "ASM_TEST_SET", "set")
+	setenv(t, "ASM_TEST_EMPTY", "")
+	setenv(t, "ASM_TEST_RETRIES", "5")
+	setenv(t, "ASM_TEST_VERIFY", "false")
+	setenv(t, "ASM_TEST_WORD", "many")
+
+	for _, tc := range []struct {
+		name  string
+		node  string
+		err   string
+		check func(Node) bool
+	}{
+		{
+			name:  "set variable",
+			node:  "endpoint: tcp://${ASM_TEST_SET}:2376",
+			check: func(n Node) bool { return n.Endpoint == "tcp://set:2376" },
+		},
+		{
+			name:  "default for unset variable",
+			node:  "endpoint: ${ASM_TEST_UNSET:-tcp://d:2376}",
+			check: func(n Node) bool { return n.Endpoint == "tcp://d:2376" },
+		},
+		{
+			name:  "default for empty variable",
+			node:  "endpoint: ${ASM_TEST_EMPTY:-tcp://d:2376}",
+			check: func(n Node) bool { return n.Endpoint == "tcp://d:2376" },
+		},
+		{
+			name:  "empty variable without colon",
+			node:  "labels: {x: \"${ASM_TEST_EMPTY-d}\"}",
+			check: func(n Node) bool { return n.Labels["x"] == "" },
+		},
+		{
+			name: "required variable",
+			node: "endpoint: ${ASM_TEST_UNSET:?needs an endpoint}",
+			err:  "3:15: nodes[0].endpoint: required variable ASM_TEST_UNSET: needs an endpoint",
+		},
+		{
+			name: "required empty variable",
+			node: "endpoint: ${ASM_TEST_EMPTY:?}",
+			err:  "required variable ASM_TEST_EMPTY: not set",
+		},
+		{
+			name:  "escaped dollar",
+			node:  "labels: {x: $$ASM_TEST_SET}",
+			check: func(n Node) bool { return n.Labels["x"] == "$ASM_TEST_SET" },
+		},
+		{
+			name:  "int target",
+			node:  "retries: ${ASM_TEST_RETRIES}",
+			check: func(n Node) bool { return n.Retries != nil && *n.Retries == 5 },
+		},
+		{
+			name:  "int target default",
+			node:  "retries: ${ASM_TEST_UNSET:-3}",
+			check: func(n Node) bool { return n.Retries != nil && *n.Retries == 3 },
+		},
+		{
+			name:  "nested int target",
+			node:  "kubernetes: {replicas: \"${ASM_TEST_RETRIES}\"}",
+			check: func(n Node) bool { return n.Kubernetes != nil && n.Kubernetes.Replicas == 5 },
+		},
+		{
+			name: "int target with a word",
+			node: "retries: ${ASM_TEST_WORD}",
+			err:  "3:14: retries must be an integer, got \"many\"",
+		},
+		{
+			name:  "bool target",
+			node:  "tls: {verify: \"${ASM_TEST_VERIFY}\"}",
+			check: func(n Node) bool { return n.TLS != nil && n.TLS.Verify != nil && !*n.TLS.Verify },
+		},
+		{
+			name:  "string target keeps numbers",
+			node:  "driverOpts: {replicas: \"${ASM_TEST_RETRIES}\"}",
+			check: func(n Node) bool { return n.DriverOpts["replicas"] == "5" },
+		},
+		{
+			name:  "duration target",
+			node:  "connectTimeout: ${ASM_TEST_UNSET:-10s}",
+			check: func(n Node) bool { return time.Duration(n.ConnectTimeout) == 10*time.Second },
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			b := "version: 2\nnodes:\n  - " + tc.node + "\n    name: a\n"
+			cfg, err := parse("/asm.yml", []byte(b))
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Fatalf("expected error %q, got %v", tc.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !tc.check(cfg.Nodes[0]) {
+				t.Errorf("unexpected node %+v", cfg.Nodes[0])
+			}
+		})
+	}
+}
@@ -0,0 +1,170 @@
+package config
+
+// Merge overlays o on top of c. Groups and profiles are merged by name and
+// their nodes are merged by node name, fields set in o take precedence.
+func (c *Config) Merge(o Config) {
+	if o.Default != "" {
+		c.Default = o.Default
+		c.defaultPos = o.defaultPos
+	}
+	c.Nodes = mergeNodes(c.Nodes, o.Nodes)
+	for name, ong := range o.Groups {
+		if c.Groups == nil {
+			c.Groups = make(map[string]NodeGroup)
+		}
+		ng := c.Groups[name]
+		ng.Nodes = mergeNodes(ng.Nodes, ong.Nodes)
+		c.Groups[name] = ng
+	}
+	for name, op := range o.Profiles {
+		if c.Profiles == nil {
+			c.Profiles = make(map[string]Profile)
+		}
+		p := c.Profiles[name]
+		p.Nodes = mergeProfileNodes(p.Nodes, op.Nodes)
+		c.Profiles[name] = p
+	}
+}
+
+func mergeNodes(nodes, other []Node) []Node {
+	// duplicates within other are kept, those are reported by Validate
+	existing := len(nodes)
+next:
+	for _, on := range other {
+		for i := range nodes[:existing] {
+			if nodes[i].Name == on.Name {
+				nodes[i].Merge(on)
+				continue next
+			}
+		}
+		nodes = append(nodes, on)
+	}
+	return nodes
+}
+
+// Merge overlays the fields set in o on top of n.
+func (n *Node) Merge(o Node) {
+	if o.Driver != "" {
+		n.Driver = o.Driver
+	}
+	if o.Endpoint != "" {
+		n.Endpoint = o.Endpoint
+	}
+	if len(o.Platforms) != 0 {
+		n.Platforms = o.Platforms
+	}
+	if len(o.Flags) != 0 {
+		n.Flags = o.Flags
+	}
+	if o.TLS != nil {
+		if n.TLS == nil {
+			n.TLS = &TLS{}
+		}
+		n.TLS.Merge(*o.TLS)
+	}
+	if o.SSH != nil {
+		if n.SSH == nil {
+			n.SSH = &SSH{}
+		}
+		n.SSH.Merge(*o.SSH)
+	}
+	if o.Kubernetes != nil {
+		if n.Kubernetes == nil {
+			n.Kubernetes = &Kubernetes{}
+		}
+		n.Kubernetes.Merge(*o.Kubernetes)
+	}
+	if o.BuildkitConfig != "" {
+		n.BuildkitConfig = o.BuildkitConfig
+	}
+	if o.ConnectTimeout != 0 {
+		n.ConnectTimeout = o.ConnectTimeout
+	}
+	if o.Retries != nil {
+		n.Retries = o.Retries
+	}
+	for k, v := range o.DriverOpts {
+		if n.DriverOpts == nil {
+			n.DriverOpts = make(map[string]string)
+		}
+		n.DriverOpts[k] = v
+	}
+	for k, v := range o.Labels {
+		if n.Labels == nil {
+			n.Labels = make(map[string]string)
+		}
+		n.Labels[k] = v
+	}
+	for k, v := range o.Files {
+		if n.Files == nil {
+			n.Files = make(map[string][]byte)
+		}
+		n.Files[k] = v
+	}
+	for k, v := range o.pos {
+		if n.pos == nil {
+			n.pos = make(map[string]Position)
+		}
+		n.pos[k] = v
+	}
+next:
+	for _, src := range o.Sources {
+		for _, s := range n.Sources {
+			if s == src {
+				continue next
+			}
+		}
+		n.Sources = append(n.Sources, src)
+	}
+}
+
+// Merge overlays the fields set in o on top of t.
+func (t *TLS) Merge(o TLS) {
+	if o.CA != "" {
+		t.CA = o.CA
+	}
+	if o.Cert != "" {
+		t.Cert = o.Cert
+	}
+	if o.Key != "" {
+		t.Key = o.Key
+	}
+	if o.Verify != nil {
+		t.Verify = o.Verify
+	}
+	if o.ServerName != "" {
+		t.ServerName = o.ServerName
+	}
+}
+
+// Merge overlays the fields set in o on top of s.
+func (s *SSH) Merge(o SSH) {
+	if o.IdentityFile != "" {
+		s.IdentityFile = o.IdentityFile
+	}
+	if o.Port != 0 {
+		s.Port = o.Port
+	}
+	if o.KnownHosts != "" {
+		s.KnownHosts = o.KnownHosts
+	}
+	if o.HostKeyCheck != "" {
+		s.HostKeyCheck = o.HostKeyCheck
+	}
+}
+
+// Merge overlays the fields set in o on top of k.
+func (k *Kubernetes) Merge(o Kubernetes) {
+	if o.Kubeconfig != "" {
+		k.Kubeconfig = o.Kubeconfig
+	}
+	if o.Context != "" {
+		k.Context = o.Context
+	}
+	if o.Namespace != "" {
+		k.Namespace = o.Namespace
+	}
+	if o.Replicas != 0 {
+		k.Replicas = o.Replicas
+	}
+}
@@ -0,0 +1,213 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	// register the drivers checked by Validate
+	_ "github.com/docker/buildx/driver/docker"
+)
+
+// setenv sets the environment variable k for the duration of the test.
+func setenv(t *testing.T, k, v string) {
+	t.Helper()
+	old, ok := os.LookupEnv(k)
+	if err := os.Setenv(k, v); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if ok {
+			os.Setenv(k, old)
+		} else {
+			os.Unsetenv(k)
+		}
+	})
+}
+
+// chdir changes the working directory for the duration of the test.
+func chdir(t *testing.T, dir string) {
+	t.Helper()
+	old, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(old) })
+}
+
+func writeConfig(t *testing.T, dir, name, contents string) string {
+	t.Helper()
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	fn := filepath.Join(dir, name)
+	if err := os.WriteFile(fn, []byte(contents), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return fn
+}
+
+func mustParse(t *testing.T, fn, contents string) Config {
+	t.Helper()
+	cfg, err := parse(fn, []byte(contents))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cfg
+}
+
+func TestMerge(t *testing.T) {
+	cfg := mustParse(t, "/base/asm.yml", `
+version: 2
+default: base
+nodes:
+  - name: a
+    driver: docker-container
+    endpoint: tcp://a:2376
+    platforms: [linux/amd64]
+    labels: {zone: eu, arch: amd64}
+    tls: {ca: ca.pem, cert: cert.pem}
+groups:
+  base:
+    nodes:
+      - name: b
+        endpoint: tcp://b:2376
+`)
+	cfg.Merge(mustParse(t, "/override/asm.yml", `
+version: 2
+nodes:
+  - name: a
+    driver: docker
+    labels: {zone: us}
+    tls: {key: key.pem}
+  - name: c
+    endpoint: tcp://c:2376
+groups:
+  base:
+    nodes:
+      - name: b
+        platforms: [linux/arm64]
+  extra:
+    nodes:
+      - name: d
+`))
+
+	if cfg.Default != "base" {
+		t.Errorf("expected the default from the base layer, got %q", cfg.Default)
+	}
+	if len(cfg.Nodes) != 2 || cfg.Nodes[0].Name != "a" || cfg.Nodes[1].Name != "c" {
+		t.Fatalf("expected nodes a and c, got %+v", cfg.Nodes)
+	}
+	a := cfg.Nodes[0]
+	if a.Driver != "docker" {
+		t.Errorf("expected the overriding driver, got %q", a.Driver)
+	}
+	if a.Endpoint != "tcp://a:2376" {
+		t.Errorf("expected the base endpoint to be kept, got %q", a.Endpoint)
+	}
+	if want := map[string]string{"zone": "us", "arch": "amd64"}; !reflect.DeepEqual(a.Labels, want) {
+		t.Errorf("expected labels %v, got %v", want, a.Labels)
+	}
+	if want := (TLS{CA: "/base/ca.pem", Cert: "/base/cert.pem", Key: "/override/key.pem"}); a.TLS == nil || *a.TLS != want {
+		t.Errorf("expected tls %+v, got %+v", want, a.TLS)
+	}
+	if want := []string{"/base/asm.yml", "/override/asm.yml"}; !reflect.DeepEqual(a.Sources, want) {
+		t.Errorf("expected sources %v, got %v", want, a.Sources)
+	}
+	if p := a.Pos("driver"); p.File != "/override/asm.yml" {
+		t.Errorf("expected the driver position in the overriding file, got %s", p)
+	}
+
+	b := cfg.Groups["base"].Nodes
+	if len(b) != 1 || b[0].Endpoint != "tcp://b:2376" || len(b[0].Platforms) != 1 {
+		t.Errorf("expected group node b to be merged, got %+v", b)
+	}
+	if d := cfg.Groups["extra"].Nodes; len(d) != 1 || d[0].Name != "d" {
+		t.Errorf("expected the new group extra, got %+v", d)
+	}
+}
+
+func TestFiles(t *testing.T) {
+	dir := t.TempDir()
+	sys1 := filepath.Join(dir, "etc1")
+	sys2 := filepath.Join(dir, "etc2")
+	setenv(t, "XDG_CONFIG_DIRS", sys1+string(filepath.ListSeparator)+sys2)
+	setenv(t, "XDG_CONFIG_HOME", filepath.Join(dir, "home"))
+
+	repo := filepath.Join(dir, "repo")
+	sub := filepath.Join(repo, "sub")
+	if err := os.MkdirAll(filepath.Join(repo, ".git"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	files := []string{
+		writeConfig(t, filepath.Join(sys2, "asm"), "asm.yml", "nodes: []"),
+		writeConfig(t, filepath.Join(sys1, "asm"), "asm.json", "{}"),
+		writeConfig(t, filepath.Join(dir, "home", "asm"), "asm.hcl", ""),
+		writeConfig(t, repo, "asm.yml", "nodes: []"),
+		writeConfig(t, sub, "asm.yaml", "nodes: []"),
+	}
+	explicit := writeConfig(t, dir, "explicit.yml", "nodes: []")
+	chdir(t, sub)
+
+	fns, err := Files([]string{explicit})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := append(files, explicit); !reflect.DeepEqual(fns, want) {
+		t.Errorf("expected files\n%v\ngot\n%v", want, fns)
+	}
+
+	// an explicit file that is also discovered moves to the end
+	fns, err = Files([]string{files[2]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{files[0], files[1], files[3], files[4], files[2]}
+	if !reflect.DeepEqual(fns, want) {
+		t.Errorf("expected files\n%v\ngot\n%v", want, fns)
+	}
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	dir := t.TempDir()
+	setenv(t, "XDG_CONFIG_DIRS", filepath.Join(dir, "etc"))
+	setenv(t, "XDG_CONFIG_HOME", filepath.Join(dir, "home"))
+	chdir(t, t.TempDir())
+
+	writeConfig(t, filepath.Join(dir, "etc", "asm"), "asm.yml", `
+version: 2
+nodes:
+  - name: a
+    driver: docker
+    endpoint: tcp://system:2376
+    labels: {layer: system}
+`)
+	writeConfig(t, filepath.Join(dir, "home", "asm"), "asm.yml", `
+version: 2
+nodes:
+  - name: a
+    endpoint: tcp://user:2376
+`)
+	explicit := writeConfig(t, dir, "explicit.yml", `
+version: 2
+nodes:
+  - name: a
+    labels: {layer: explicit}
+`)
+
+	cfg, err := Load([]string{explicit}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cfg.Nodes) != 1 {
+		t.Fatalf("expected a single node, got %+v", cfg.Nodes)
+	}
+	if n := cfg.Nodes[0]; n.Endpoint != "tcp://user:2376" || n.Labels["layer"] != "explicit" {
+		t.Errorf("expected the user endpoint and the explicit label, got %+v", n)
+	}
+}
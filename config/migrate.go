@@ -0,0 +1,267 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/containerd/containerd/platforms"
+	specs "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// Version is the newest config schema version. Files without a version key
+// are version 0.
+const Version = 2
+
+// migrations[i] upgrades a document from version i to i+1.
+var migrations = []func(root *yaml.Node) error{
+	// 0 -> 1: keys are spelled out instead of following store.Node and
+	// platforms are written as strings
+	migrateV1,
+	// 1 -> 2: the ca, cert and key driver options move into a tls block
+	migrateV2,
+}
+
+// node keys of version 1
+var nodeKeysV1 = []string{"name", "driver", "endpoint", "platforms", "flags", "driverOpts", "files"}
+
+// checkVersion parses the config version s, a non-negative integer no newer
+// than Version.
+func checkVersion(s string) (int, error) {
+	version, err := strconv.Atoi(s)
+	if err != nil || version < 0 {
+		return 0, errors.Errorf("invalid config version %q, versions are non-negative integers", s)
+	}
+	if version > Version {
+		return 0, errors.Errorf("config version %d is not supported, the newest version is %d", version, Version)
+	}
+	return version, nil
+}
+
+// migrate upgrades doc to the newest schema version in place and returns the
+// version it was written in.
+func migrate(doc *yaml.Node) (int, error) {
+	if doc.Kind != yaml.DocumentNode || len(doc.Content) == 0 {
+		return Version, nil
+	}
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return 0, errors.New("config must be a mapping")
+	}
+
+	version := 0
+	k, v := mappingValue(root, "version")
+	if v != nil {
+		var err error
+		if version, err = checkVersion(v.Value); err != nil {
+			return 0, &ValidationError{Pos: Position{Line: v.Line, Column: v.Column}, Msg: err.Error()}
+		}
+	}
+	for _, m := range migrations[version:] {
+		if err := m(root); err != nil {
+			return 0, err
+		}
+	}
+
+	if v == nil {
+		k = &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str"}
+		v = &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!int"}
+		if len(root.Content) != 0 {
+			// the comment heading the file stays on top
+			k.HeadComment, root.Content[0].HeadComment = root.Content[0].HeadComment, ""
+		}
+		root.Content = append([]*yaml.Node{k, v}, root.Content...)
+	}
+	k.Value, v.Value = "version", strconv.Itoa(Version)
+	return version, nil
+}
+
+// mappingValue returns the key and value nodes of key in the mapping m,
+// matching keys case-insensitively.
+func mappingValue(m *yaml.Node, key string) (*yaml.Node, *yaml.Node) {
+	if m == nil || m.Kind != yaml.MappingNode {
+		return nil, nil
+	}
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		if strings.EqualFold(m.Content[i].Value, key) {
+			return m.Content[i], m.Content[i+1]
+		}
+	}
+	return nil, nil
+}
+
+// nodeLists returns the sequences of nodes declared in root, including
+// those of groups and profiles.
+func nodeLists(root *yaml.Node) []*yaml.Node {
+	var lists []*yaml.Node
+	if _, nodes := mappingValue(root, "nodes"); nodes != nil {
+		lists = append(lists, nodes)
+	}
+	for _, key := range []string{"groups", "profiles"} {
+		if _, m := mappingValue(root, key); m != nil && m.Kind == yaml.MappingNode {
+			for i := 1; i < len(m.Content); i += 2 {
+				if _, nodes := mappingValue(m.Content[i], "nodes"); nodes != nil {
+					lists = append(lists, nodes)
+				}
+			}
+		}
+	}
+	return lists
+}
+
+func migrateV1(root *yaml.Node) error {
+	for _, key := range []string{"default", "nodes", "groups", "profiles"} {
+		if k, _ := mappingValue(root, key); k != nil {
+			k.Value = key
+		}
+	}
+	for _, key := range []string{"groups", "profiles"} {
+		if _, m := mappingValue(root, key); m != nil && m.Kind == yaml.MappingNode {
+			for i := 1; i < len(m.Content); i += 2 {
+				if k, _ := mappingValue(m.Content[i], "nodes"); k != nil {
+					k.Value = "nodes"
+				}
+			}
+		}
+	}
+
+	for _, list := range nodeLists(root) {
+		for _, n := range list.Content {
+			for _, key := range nodeKeysV1 {
+				if k, _ := mappingValue(n, key); k != nil {
+					k.Value = key
+				}
+			}
+			_, ps := mappingValue(n, "platforms")
+			if ps == nil || ps.Kind != yaml.SequenceNode {
+				continue
+			}
+			for _, p := range ps.Content {
+				if p.Kind != yaml.MappingNode {
+					continue
+				}
+				var m map[string]interface{}
+				if err := p.Decode(&m); err != nil {
+					return err
+				}
+				b, err := json.Marshal(m)
+				if err != nil {
+					return err
+				}
+				var spec specs.Platform
+				if err := json.Unmarshal(b, &spec); err != nil {
+					return &ValidationError{Pos: Position{Line: p.Line, Column: p.Column}, Msg: err.Error()}
+				}
+				*p = yaml.Node{
+					Kind:        yaml.ScalarNode,
+					Tag:         "!!str",
+					Value:       platforms.Format(spec),
+					Line:        p.Line,
+					Column:      p.Column,
+					HeadComment: p.HeadComment,
+					LineComment: p.LineComment,
+					FootComment: p.FootComment,
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func migrateV2(root *yaml.Node) error {
+	for _, list := range nodeLists(root) {
+		for _, n := range list.Content {
+			_, opts := mappingValue(n, "driverOpts")
+			if opts == nil || opts.Kind != yaml.MappingNode {
+				continue
+			}
+			var moved []*yaml.Node
+			for i := 0; i+1 < len(opts.Content); {
+				switch opts.Content[i].Value {
+				case "ca", "cert", "key":
+					moved = append(moved, opts.Content[i:i+2]...)
+					opts.Content = append(opts.Content[:i], opts.Content[i+2:]...)
+				default:
+					i += 2
+				}
+			}
+			if len(moved) == 0 {
+				continue
+			}
+			if len(opts.Content) == 0 {
+				removeKey(n, "driverOpts")
+			}
+			_, t := mappingValue(n, "tls")
+			if t == nil {
+				t = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+				n.Content = append(n.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: "tls"}, t)
+			}
+			for i := 0; i < len(moved); i += 2 {
+				if k, _ := mappingValue(t, moved[i].Value); k != nil {
+					return &ValidationError{
+						Pos: Position{Line: moved[i].Line, Column: moved[i].Column},
+						Msg: fmt.Sprintf("%s is set in both driverOpts and tls", moved[i].Value),
+					}
+				}
+			}
+			t.Content = append(t.Content, moved...)
+		}
+	}
+	return nil
+}
+
+// removeKey removes key and its value from the mapping m.
+func removeKey(m *yaml.Node, key string) {
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		if strings.EqualFold(m.Content[i].Value, key) {
+			m.Content = append(m.Content[:i], m.Content[i+2:]...)
+			return
+		}
+	}
+}
+
+// Migrate rewrites the config file fn in the newest schema version, keeping
+// the comments of yaml files. It returns the new contents and the version
+// the file was written in.
+func Migrate(fn string) ([]byte, int, error) {
+	b, err := ioutil.ReadFile(fn)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	switch filepath.Ext(fn) {
+	case ".yaml", ".yml", ".json":
+	case ".hcl":
+		return nil, 0, errors.Errorf("%s: hcl configs are always in the newest version", fn)
+	default:
+		return nil, 0, errors.Errorf("%s: format not supported: %s", fn, filepath.Ext(fn))
+	}
+
+	if filepath.Ext(fn) != ".json" {
+		b = markBlank(b)
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(b, &doc); err != nil {
+		return nil, 0, errors.Wrap(err, fn)
+	}
+	from, err := migrate(&doc)
+	if err != nil {
+		var verr *ValidationError
+		if errors.As(err, &verr) {
+			verr.Pos.File = fn
+			return nil, 0, err
+		}
+		return nil, 0, errors.Wrap(err, fn)
+	}
+
+	b, err = encode(&doc, filepath.Ext(fn))
+	if err != nil {
+		return nil, 0, errors.Wrap(err, fn)
+	}
+	return b, from, nil
+}
@@ -0,0 +1,91 @@
+package config
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestMigrate(t *testing.T) {
+	fn := writeConfig(t, t.TempDir(), "asm.yml", `# build nodes
+Nodes:
+  - Name: a
+    Driver: docker
+    Endpoint: tcp://a:2376
+    Platforms:
+      - {os: linux, architecture: arm64, variant: v8}
+    DriverOpts:
+      ca: ca.pem # the ca
+      cert: cert.pem
+      key: key.pem
+groups:
+  arm:
+    Nodes:
+      - Name: b
+        Driver: docker
+        DriverOpts: {key: key.pem, cert: cert.pem}
+Profiles:
+  ci:
+    Nodes:
+      - Name: a
+        Platforms:
+          - {os: linux, architecture: amd64}
+        DriverOpts:
+          ca: ci-ca.pem
+          image: moby/buildkit
+        unset: [flags]
+`)
+	b, from, err := Migrate(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if from != 0 {
+		t.Errorf("expected version 0, got %d", from)
+	}
+	want := `# build nodes
+version: 2
+nodes:
+  - name: a
+    driver: docker
+    endpoint: tcp://a:2376
+    platforms:
+      - linux/arm64/v8
+    tls:
+      ca: ca.pem # the ca
+      cert: cert.pem
+      key: key.pem
+groups:
+  arm:
+    nodes:
+      - name: b
+        driver: docker
+        tls:
+          key: key.pem
+          cert: cert.pem
+profiles:
+  ci:
+    nodes:
+      - name: a
+        platforms:
+          - linux/amd64
+        driverOpts:
+          image: moby/buildkit
+        unset: [flags]
+        tls:
+          ca: ci-ca.pem
+`
+	if string(b) != want {
+		t.Errorf("expected\n%s\ngot\n%s", want, b)
+	}
+
+	cfg, err := parse(fn, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := cfg.Profiles["ci"].Nodes
+	if len(p) != 1 || p[0].TLS == nil || p[0].TLS.CA != filepath.Join(filepath.Dir(fn), "ci-ca.pem") {
+		t.Errorf("expected the profile ca to move into tls, got %+v", p)
+	}
+	if len(p[0].Platforms) != 1 || p[0].Platforms[0].Architecture != "amd64" {
+		t.Errorf("expected the profile platform to be migrated, got %+v", p[0].Platforms)
+	}
+}
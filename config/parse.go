@@ -0,0 +1,407 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/containerd/containerd/platforms"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// Position is a location in a config file.
+type Position struct {
+	File   string
+	Line   int
+	Column int
+}
+
+func (p Position) String() string {
+	if p.Line == 0 {
+		return p.File
+	}
+	return fmt.Sprintf("%s:%d:%d", p.File, p.Line, p.Column)
+}
+
+// Parse reads a single config file, expanding environment variables in all
+// of its values.
+func Parse(fn string) (cfg Config, err error) {
+	b, err := ioutil.ReadFile(fn)
+	if err != nil {
+		return cfg, errors.Wrap(err, fn)
+	}
+	return parse(fn, b)
+}
+
+// parse is Parse with b as the contents of fn.
+func parse(fn string, b []byte) (cfg Config, err error) {
+	defer func() {
+		var (
+			verr  *ValidationError
+			verrs ValidationErrors
+		)
+		if err != nil && !errors.As(err, &verr) && !errors.As(err, &verrs) {
+			err = errors.Wrap(err, fn)
+		}
+	}()
+
+	switch filepath.Ext(fn) {
+	case ".yaml", ".yml":
+		cfg, err = parseYAML(fn, b, "yaml")
+	case ".json":
+		cfg, err = parseYAML(fn, b, "json")
+	case ".hcl":
+		cfg, err = parseHCL(fn, b)
+	default:
+		err = errors.Errorf("format not supported: %s", filepath.Ext(fn))
+	}
+	if err != nil {
+		return cfg, err
+	}
+	cfg.resolvePaths(filepath.Dir(fn))
+	return cfg, nil
+}
+
+func parseYAML(fn string, b []byte, format string) (cfg Config, err error) {
+	// json is parsed as yaml as well, to learn the position of every value
+	var doc yaml.Node
+	if err := yaml.Unmarshal(b, &doc); err != nil {
+		return cfg, fmt.Errorf("error parsing %s: %w", format, err)
+	}
+	version, err := migrate(&doc)
+	if err != nil {
+		var verr *ValidationError
+		if errors.As(err, &verr) {
+			verr.Pos.File = fn
+		}
+		return cfg, err
+	}
+	pos := make(map[string]Position)
+	var errs ValidationErrors
+	v := decode(fn, &doc, "", pos, &errs)
+	v = interpolate(v, reflect.TypeOf(cfg), "", pos, &errs)
+	// older files were decoded leniently
+	checkTypes(v, reflect.TypeOf(cfg), "", pos, version > 0, &errs)
+	checkValues(v, pos, &errs)
+	if len(errs) != 0 {
+		errs.sortByPos()
+		return cfg, errs
+	}
+
+	if b, err = json.Marshal(v); err != nil {
+		return cfg, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	if version > 0 {
+		dec.DisallowUnknownFields()
+	}
+	if err := dec.Decode(&cfg); err != nil {
+		var terr *json.UnmarshalTypeError
+		if errors.As(err, &terr) && terr.Field != "" {
+			return cfg, &ValidationError{Pos: pos[jsonFieldPath(terr.Field)], Msg: err.Error()}
+		}
+		return cfg, fmt.Errorf("error parsing %s: %w", format, err)
+	}
+	cfg.Version = version
+	cfg.setPositions(fn, pos)
+	return cfg, nil
+}
+
+// resolvePaths makes the paths in the node configs relative to dir, the
+// directory of the file declaring them.
+func (c *Config) resolvePaths(dir string) {
+	resolve := func(n *Node) {
+		if t := n.TLS; t != nil {
+			for _, p := range []*string{&t.CA, &t.Cert, &t.Key} {
+				if *p != "" {
+					*p = resolvePath(dir, *p)
+				}
+			}
+		}
+		if s := n.SSH; s != nil {
+			for _, p := range []*string{&s.IdentityFile, &s.KnownHosts} {
+				if *p != "" {
+					*p = resolvePath(dir, *p)
+				}
+			}
+		}
+		if k := n.Kubernetes; k != nil && k.Kubeconfig != "" {
+			k.Kubeconfig = resolvePath(dir, k.Kubeconfig)
+		}
+		if n.BuildkitConfig != "" {
+			n.BuildkitConfig = resolvePath(dir, n.BuildkitConfig)
+		}
+	}
+	for _, ng := range c.AllGroups() {
+		for i := range ng.Nodes {
+			resolve(&ng.Nodes[i])
+		}
+	}
+	for _, p := range c.Profiles {
+		for i := range p.Nodes {
+			resolve(&p.Nodes[i].Node)
+		}
+	}
+}
+
+func resolvePath(dir, p string) string {
+	if p == "~" || strings.HasPrefix(p, "~/") {
+		if home, err := os.UserHomeDir(); err == nil {
+			return filepath.Join(home, p[1:])
+		}
+	}
+	if filepath.IsAbs(p) {
+		return p
+	}
+	return filepath.Join(dir, p)
+}
+
+// decode converts n into the values encoding/json would produce, recording
+// the position of each of them keyed by its lowercase field path. Values
+// that can not be decoded are reported in errs and left out.
+func decode(fn string, n *yaml.Node, field string, pos map[string]Position, errs *ValidationErrors) interface{} {
+	pos[strings.ToLower(field)] = Position{File: fn, Line: n.Line, Column: n.Column}
+
+	switch n.Kind {
+	case yaml.DocumentNode:
+		if len(n.Content) == 0 {
+			return nil
+		}
+		return decode(fn, n.Content[0], field, pos, errs)
+	case yaml.AliasNode:
+		return decode(fn, n.Alias, field, pos, errs)
+	case yaml.MappingNode:
+		m := make(map[string]interface{}, len(n.Content)/2)
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			k, v := n.Content[i], n.Content[i+1]
+			f := k.Value
+			if field != "" {
+				f = field + "." + k.Value
+			}
+			if _, ok := m[k.Value]; ok {
+				*errs = append(*errs, &ValidationError{Pos: Position{File: fn, Line: k.Line, Column: k.Column}, Msg: fmt.Sprintf("duplicate key %q", k.Value)})
+				continue
+			}
+			m[k.Value] = decode(fn, v, f, pos, errs)
+		}
+		return m
+	case yaml.SequenceNode:
+		s := make([]interface{}, len(n.Content))
+		for i, v := range n.Content {
+			s[i] = decode(fn, v, fmt.Sprintf("%s[%d]", field, i), pos, errs)
+		}
+		return s
+	}
+
+	if n.ShortTag() == "!!timestamp" {
+		return n.Value
+	}
+	var v interface{}
+	if err := n.Decode(&v); err != nil {
+		*errs = append(*errs, &ValidationError{Pos: Position{File: fn, Line: n.Line, Column: n.Column}, Msg: err.Error()})
+		return nil
+	}
+	return v
+}
+
+// checkTypes reports the values of v that do not fit the type t they are
+// decoded into and, if strict is set, unknown fields.
+func checkTypes(v interface{}, t reflect.Type, field string, pos map[string]Position, strict bool, errs *ValidationErrors) {
+	t = indirect(t)
+	if v == nil || t == nil || reflect.PtrTo(t).Implements(jsonUnmarshaler) {
+		// custom types are checked by checkValues
+		return
+	}
+	name := field[strings.LastIndex(field, ".")+1:]
+	mismatch := func(want string) {
+		var got string
+		switch v := v.(type) {
+		case map[string]interface{}:
+			got = "a mapping"
+		case []interface{}:
+			got = "a list"
+		case string:
+			got = fmt.Sprintf("%q", v)
+		default:
+			got = fmt.Sprint(v)
+		}
+		*errs = append(*errs, &ValidationError{Pos: pos[strings.ToLower(field)], Msg: fmt.Sprintf("%s must be %s, got %s", name, want, got)})
+	}
+	join := func(k string) string {
+		if field == "" {
+			return k
+		}
+		return field + "." + k
+	}
+
+	switch t.Kind() {
+	case reflect.Struct, reflect.Map:
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			mismatch("a mapping")
+			return
+		}
+		keys := make([]string, 0, len(m))
+		for k := range m {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			ft := fieldType(t, k)
+			if ft == nil {
+				if strict {
+					*errs = append(*errs, &ValidationError{Pos: pos[strings.ToLower(join(k))], Msg: fmt.Sprintf("unknown field %q", k)})
+				}
+				continue
+			}
+			checkTypes(m[k], ft, join(k), pos, strict, errs)
+		}
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.Uint8 {
+			if _, ok := v.(string); !ok {
+				mismatch("a string")
+			}
+			return
+		}
+		s, ok := v.([]interface{})
+		if !ok {
+			mismatch("a list")
+			return
+		}
+		for i := range s {
+			checkTypes(s[i], t.Elem(), fmt.Sprintf("%s[%d]", field, i), pos, strict, errs)
+		}
+	case reflect.String:
+		if _, ok := v.(string); !ok {
+			mismatch("a string")
+		}
+	case reflect.Bool:
+		if _, ok := v.(bool); !ok {
+			mismatch("true or false")
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		switch v := v.(type) {
+		case int, int64, uint64:
+		case float64:
+			if v != float64(int64(v)) {
+				mismatch("an integer")
+			}
+		default:
+			mismatch("an integer")
+		}
+	case reflect.Float32, reflect.Float64:
+		switch v.(type) {
+		case int, int64, uint64, float64:
+		default:
+			mismatch("a number")
+		}
+	}
+}
+
+// jsonFieldPath converts the path of a field in a json error, like
+// nodes.0.retries, to the field path positions are recorded under.
+func jsonFieldPath(field string) string {
+	parts := strings.Split(field, ".")
+	var b strings.Builder
+	for i, p := range parts {
+		if _, err := strconv.Atoi(p); err == nil && i > 0 {
+			fmt.Fprintf(&b, "[%s]", p)
+			continue
+		}
+		if i > 0 {
+			b.WriteByte('.')
+		}
+		b.WriteString(p)
+	}
+	return strings.ToLower(b.String())
+}
+
+// checkValues reports platforms and durations that can not be parsed, at
+// their position.
+func checkValues(v interface{}, pos map[string]Position, errs *ValidationErrors) {
+	root, _ := v.(map[string]interface{})
+
+	groups := map[string]interface{}{
+		"nodes": root["nodes"],
+	}
+	gm, _ := root["groups"].(map[string]interface{})
+	for name, ng := range gm {
+		if ngm, ok := ng.(map[string]interface{}); ok {
+			groups["groups."+name+".nodes"] = ngm["nodes"]
+		}
+	}
+	pm, _ := root["profiles"].(map[string]interface{})
+	for name, p := range pm {
+		if ppm, ok := p.(map[string]interface{}); ok {
+			groups["profiles."+name+".nodes"] = ppm["nodes"]
+		}
+	}
+
+	for group, nodes := range groups {
+		ns, _ := nodes.([]interface{})
+		for i, n := range ns {
+			nm, _ := n.(map[string]interface{})
+			if d, ok := nm["connectTimeout"]; ok {
+				field := fmt.Sprintf("%s[%d].connectTimeout", group, i)
+				if s, ok := d.(string); !ok {
+					*errs = append(*errs, &ValidationError{Pos: pos[strings.ToLower(field)], Msg: "connectTimeout must be a duration like 10s"})
+				} else if _, err := time.ParseDuration(s); err != nil {
+					*errs = append(*errs, &ValidationError{Pos: pos[strings.ToLower(field)], Msg: err.Error()})
+				}
+			}
+			pl, _ := nm["platforms"].([]interface{})
+			for j, p := range pl {
+				s, ok := p.(string)
+				if !ok {
+					continue
+				}
+				if _, err := platforms.Parse(s); err != nil {
+					field := fmt.Sprintf("%s[%d].platforms[%d]", group, i, j)
+					*errs = append(*errs, &ValidationError{Pos: pos[strings.ToLower(field)], Msg: err.Error()})
+				}
+			}
+		}
+	}
+}
+
+// setPositions hands the positions recorded while decoding fn to the nodes
+// declared there.
+func (c *Config) setPositions(fn string, pos map[string]Position) {
+	set := func(p string, n *Node) {
+		n.Sources = []string{fn}
+		n.pos = make(map[string]Position)
+		for k, v := range pos {
+			switch {
+			case k == p:
+				n.pos[""] = v
+			case strings.HasPrefix(k, p+"."):
+				n.pos[strings.TrimPrefix(k, p+".")] = v
+			}
+		}
+	}
+
+	c.defaultPos = pos["default"]
+	for i := range c.Nodes {
+		set(fmt.Sprintf("nodes[%d]", i), &c.Nodes[i])
+	}
+	for name, ng := range c.Groups {
+		for i := range ng.Nodes {
+			set(strings.ToLower(fmt.Sprintf("groups.%s.nodes[%d]", name, i)), &ng.Nodes[i])
+		}
+	}
+	for name, p := range c.Profiles {
+		for i := range p.Nodes {
+			set(strings.ToLower(fmt.Sprintf("profiles.%s.nodes[%d]", name, i)), &p.Nodes[i].Node)
+		}
+	}
+}
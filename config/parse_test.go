@@ -0,0 +1,85 @@
+package config
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		fn   string
+		b    string
+		errs []string
+	}{
+		{
+			name: "yaml",
+			fn:   "/asm.yml",
+			b: `version: 2
+nodes:
+  - name: a
+    driver: docker
+    retries: many
+    colour: red
+    platforms: [linux/amd64, not/a/valid/platform]
+  - name: b
+    name: c
+    tls:
+      verify: maybe
+    connectTimeout: 10
+`,
+			errs: []string{
+				`/asm.yml:5:14: retries must be an integer, got "many"`,
+				`/asm.yml:6:13: unknown field "colour"`,
+				`/asm.yml:7:30: "not/a/valid/platform": cannot parse platform specifier: invalid argument`,
+				`/asm.yml:9:5: duplicate key "name"`,
+				`/asm.yml:11:15: verify must be true or false, got "maybe"`,
+				`/asm.yml:12:21: connectTimeout must be a duration like 10s`,
+			},
+		},
+		{
+			name: "json",
+			fn:   "/asm.json",
+			b: `{
+  "version": 2,
+  "nodes": [
+    {"name": "a", "retries": "3"},
+    {"name": "b", "labels": ["x"]}
+  ]
+}
+`,
+			errs: []string{
+				`/asm.json:4:30: retries must be an integer, got "3"`,
+				`/asm.json:5:29: labels must be a mapping, got a list`,
+			},
+		},
+		{
+			name: "lenient version 0",
+			fn:   "/asm.yml",
+			b: `nodes:
+  - name: a
+    colour: red
+    retries: many
+`,
+			errs: []string{
+				`/asm.yml:4:14: retries must be an integer, got "many"`,
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := parse(tc.fn, []byte(tc.b))
+			var verrs ValidationErrors
+			if !errors.As(err, &verrs) {
+				t.Fatalf("expected validation errors, got %v", err)
+			}
+			if len(verrs) != len(tc.errs) {
+				t.Fatalf("expected %d errors, got %d:\n%s", len(tc.errs), len(verrs), verrs)
+			}
+			for i, e := range verrs {
+				if e.Error() != tc.errs[i] {
+					t.Errorf("expected error\n%s\ngot\n%s", tc.errs[i], e)
+				}
+			}
+		})
+	}
+}
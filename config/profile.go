@@ -0,0 +1,171 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Profile changes the nodes of the same name in every group while it is
+// active.
+type Profile struct {
+	Nodes []ProfileNode `json:"nodes"`
+}
+
+// ProfileNode is merged into the nodes of the same name, after removing the
+// fields listed in Unset.
+type ProfileNode struct {
+	Node
+	// Unset lists the fields removed from the node, as "field" or
+	// "field.key"
+	Unset []string `json:"unset,omitempty"`
+}
+
+// WithProfile returns the config with the profile called name applied to its
+// nodes.
+func (c Config) WithProfile(name string) (Config, error) {
+	p, ok := c.Profiles[name]
+	if !ok {
+		return c, errors.Errorf("no such profile: %s", name)
+	}
+
+	apply := func(nodes []Node) []Node {
+		out := make([]Node, len(nodes))
+		for i, n := range nodes {
+			out[i] = n
+			for _, pn := range p.Nodes {
+				if pn.Name == n.Name {
+					out[i] = pn.apply(out[i])
+				}
+			}
+		}
+		return out
+	}
+	c.Nodes = apply(c.Nodes)
+	if c.Groups != nil {
+		groups := make(map[string]NodeGroup, len(c.Groups))
+		for name, ng := range c.Groups {
+			ng.Nodes = apply(ng.Nodes)
+			groups[name] = ng
+		}
+		c.Groups = groups
+	}
+	return c, nil
+}
+
+// apply returns a copy of n changed by pn.
+func (pn ProfileNode) apply(n Node) Node {
+	out := Node{Name: n.Name}
+	out.Merge(n)
+	for _, field := range pn.Unset {
+		// checked by Validate
+		_ = out.unset(field)
+	}
+	out.Merge(pn.Node)
+	return out
+}
+
+// unset clears field of n, given as "field" or "field.key".
+func (n *Node) unset(field string) error {
+	parts := strings.SplitN(field, ".", 2)
+	f, ok := jsonField(reflect.ValueOf(n).Elem(), parts[0])
+	if !ok || strings.EqualFold(parts[0], "name") {
+		return errors.Errorf("unknown field %q", field)
+	}
+	if len(parts) == 1 {
+		f.Set(reflect.Zero(f.Type()))
+		return nil
+	}
+
+	switch f.Kind() {
+	case reflect.Map:
+		if !f.IsNil() {
+			f.SetMapIndex(reflect.ValueOf(parts[1]), reflect.Value{})
+		}
+	case reflect.Ptr:
+		if f.IsNil() {
+			// nothing to clear, but the field is still checked
+			f = reflect.New(f.Type().Elem())
+		}
+		sub, ok := jsonField(f.Elem(), parts[1])
+		if !ok {
+			return errors.Errorf("unknown field %q", field)
+		}
+		sub.Set(reflect.Zero(sub.Type()))
+	default:
+		return errors.Errorf("field %q has no keys", parts[0])
+	}
+	return nil
+}
+
+// jsonField returns the field of the struct v encoded as name.
+func jsonField(v reflect.Value, name string) (reflect.Value, bool) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		tag := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+		if tag != "" && tag != "-" && strings.EqualFold(tag, name) {
+			return v.Field(i), true
+		}
+	}
+	return reflect.Value{}, false
+}
+
+func mergeProfileNodes(nodes, other []ProfileNode) []ProfileNode {
+	// duplicates within other are kept, those are reported by Validate
+	existing := len(nodes)
+next:
+	for _, on := range other {
+		for i := range nodes[:existing] {
+			if nodes[i].Name == on.Name {
+				nodes[i].Node.Merge(on.Node)
+				nodes[i].Unset = append(nodes[i].Unset, on.Unset...)
+				continue next
+			}
+		}
+		nodes = append(nodes, on)
+	}
+	return nodes
+}
+
+// validateProfiles checks that every profile node changes a known node and
+// only unsets known fields.
+func (c Config) validateProfiles() (errs ValidationErrors) {
+	known := make(map[string]bool)
+	for _, ng := range c.AllGroups() {
+		for _, n := range ng.Nodes {
+			known[n.Name] = true
+		}
+	}
+
+	names := make([]string, 0, len(c.Profiles))
+	for name := range c.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		seen := make(map[string]ProfileNode)
+		for _, pn := range c.Profiles[name].Nodes {
+			errorf := func(field, format string, args ...interface{}) {
+				errs = append(errs, &ValidationError{Pos: pn.Pos(field), Msg: fmt.Sprintf(format, args...)})
+			}
+			if first, ok := seen[pn.Name]; ok {
+				errorf("name", "duplicate node name %q in profile %s, first declared at %s", pn.Name, name, first.Pos("name"))
+			} else {
+				seen[pn.Name] = pn
+			}
+			if !known[pn.Name] {
+				errorf("name", "profile %s changes unknown node %q", name, pn.Name)
+			}
+			for i, field := range pn.Unset {
+				var n Node
+				if err := n.unset(field); err != nil {
+					errorf(fmt.Sprintf("unset[%d]", i), "profile %s, node %q: %s", name, pn.Name, err)
+				}
+			}
+		}
+	}
+	return errs
+}
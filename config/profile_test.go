@@ -0,0 +1,96 @@
+package config
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+const profileConfig = `version: 2
+nodes:
+  - name: a
+    driver: docker
+    endpoint: tcp://a:2376
+    flags: [--debug]
+    labels: {zone: eu, arch: amd64}
+groups:
+  arm:
+    nodes:
+      - name: a
+        driver: docker
+        endpoint: tcp://a-arm:2376
+      - name: b
+        driver: docker
+        endpoint: tcp://b:2376
+profiles:
+  ci:
+    nodes:
+      - name: a
+        endpoint: tcp://ci:2376
+        labels: {zone: us}
+        unset: [flags, labels.arch]
+      - name: unknown
+        endpoint: tcp://unknown:2376
+`
+
+func TestWithProfile(t *testing.T) {
+	cfg := mustParse(t, "/asm.yml", profileConfig)
+	p, err := cfg.WithProfile("ci")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a := p.Nodes[0]
+	if a.Endpoint != "tcp://ci:2376" || a.Driver != "docker" {
+		t.Errorf("expected the profile endpoint and the node driver, got %+v", a)
+	}
+	if len(a.Flags) != 0 {
+		t.Errorf("expected flags to be unset, got %v", a.Flags)
+	}
+	if want := map[string]string{"zone": "us"}; !reflect.DeepEqual(a.Labels, want) {
+		t.Errorf("expected labels %v, got %v", want, a.Labels)
+	}
+	if arm := p.Groups["arm"].Nodes; arm[0].Endpoint != "tcp://ci:2376" || arm[1].Endpoint != "tcp://b:2376" {
+		t.Errorf("expected only node a of group arm to change, got %+v", arm)
+	}
+
+	// nodes only declared by the profile are not added
+	if len(p.Nodes) != 1 || len(p.Groups["arm"].Nodes) != 2 {
+		t.Errorf("expected no new nodes, got %+v and %+v", p.Nodes, p.Groups["arm"].Nodes)
+	}
+
+	// the config itself is unchanged
+	if n := cfg.Nodes[0]; n.Endpoint != "tcp://a:2376" || len(n.Flags) != 1 || len(n.Labels) != 2 {
+		t.Errorf("expected the config to be unchanged, got %+v", n)
+	}
+
+	if _, err := cfg.WithProfile("nope"); err == nil {
+		t.Error("expected an error for an unknown profile")
+	}
+}
+
+func TestValidateProfiles(t *testing.T) {
+	cfg := mustParse(t, "/asm.yml", profileConfig+`      - name: a
+        unset: [colour, tls.colour, driver.x]
+`)
+	err := cfg.Validate()
+	var verrs ValidationErrors
+	if !errors.As(err, &verrs) {
+		t.Fatalf("expected validation errors, got %v", err)
+	}
+	want := []string{
+		`/asm.yml:24:15: profile ci changes unknown node "unknown"`,
+		`/asm.yml:26:15: duplicate node name "a" in profile ci, first declared at /asm.yml:20:15`,
+		`/asm.yml:27:17: profile ci, node "a": unknown field "colour"`,
+		`/asm.yml:27:25: profile ci, node "a": unknown field "tls.colour"`,
+		`/asm.yml:27:37: profile ci, node "a": field "driver" has no keys`,
+	}
+	if len(verrs) != len(want) {
+		t.Fatalf("expected %d errors, got %d:\n%s", len(want), len(verrs), verrs)
+	}
+	for i, e := range verrs {
+		if e.Error() != want[i] {
+			t.Errorf("expected error\n%s\ngot\n%s", want[i], e)
+		}
+	}
+}
@@ -0,0 +1,202 @@
+package config
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/docker/buildx/driver"
+
+	asmdriver "github.com/robertgzr/asm/driver"
+)
+
+// descriptions of the config fields in the schema, keyed by type and field
+var descriptions = map[string]string{
+	"Config.Version":        "schema version the file is written in",
+	"Config.Default":        "group used when none is selected with --group",
+	"Config.Nodes":          "nodes of the default group",
+	"Config.Groups":         "named node groups",
+	"Config.Profiles":       "profiles changing the nodes of the same name when activated with --profile",
+	"ProfileNode.Unset":     "fields removed from the node, like tls or labels.KEY",
+	"NodeGroup.Nodes":       "nodes of the group",
+	"Profile.Nodes":         "changes to the nodes of the same name",
+	"Node.Name":             "unique name of the node, nodes with the same name are merged across files",
+	"Node.Driver":           "buildx driver used for the node",
+	"Node.Endpoint":         "address of the docker daemon, like unix:///var/run/docker.sock or tcp://host:2376, or of buildkitd for the remote driver",
+	"Node.Platforms":        "platforms built on the node, like linux/arm64",
+	"Node.Flags":            "flags passed to buildkitd",
+	"Node.DriverOpts":       "options of the driver, see the driver specific schemas",
+	"Node.Files":            "files passed to buildkitd, base64 encoded",
+	"Node.BuildkitConfig":   "path of a buildkitd.toml, relative to this file",
+	"Node.Labels":           "labels matched by node selectors",
+	"Node.TLS":              "tls configuration of the endpoint",
+	"Node.SSH":              "ssh configuration of ssh:// endpoints",
+	"Node.Kubernetes":       "cluster of the kubernetes driver",
+	"Node.ConnectTimeout":   "time limit of each attempt to connect to the node, like 10s",
+	"Node.Retries":          "number of times a failed connection is retried",
+	"TLS.CA":                "CA certificate the server is verified with, relative to this file",
+	"TLS.Cert":              "client certificate, relative to this file",
+	"TLS.Key":               "client key, relative to this file",
+	"TLS.Verify":            "verify the server certificate, defaults to true",
+	"TLS.ServerName":        "name the server certificate is verified for",
+	"SSH.IdentityFile":      "private key used to log in, relative to this file",
+	"SSH.Port":              "port of the ssh server, if the endpoint does not include one",
+	"SSH.KnownHosts":        "known_hosts file the host key is checked against, relative to this file",
+	"SSH.HostKeyCheck":      "ssh StrictHostKeyChecking option: yes, no or accept-new",
+	"Kubernetes.Kubeconfig": "kubeconfig file, relative to this file, defaults to $KUBECONFIG or ~/.kube/config",
+	"Kubernetes.Context":    "kubeconfig context, defaults to the current context",
+	"Kubernetes.Namespace":  "namespace buildkit is deployed to, defaults to the namespace of the context",
+	"Kubernetes.Replicas":   "number of buildkit pods",
+}
+
+var (
+	nodeType      = reflect.TypeOf(Node{})
+	platformsType = reflect.TypeOf(Platforms{})
+	durationType  = reflect.TypeOf(Duration(0))
+)
+
+// Schema returns a JSON schema of the config format in the newest version.
+// The driver options are described for every registered driver.
+func Schema() ([]byte, error) {
+	root := typeSchema(reflect.TypeOf(Config{}))
+	root["$schema"] = "http://json-schema.org/draft-07/schema#"
+	root["title"] = "asm node configuration"
+	root["properties"].(map[string]interface{})["version"].(map[string]interface{})["maximum"] = Version
+
+	node := typeSchema(nodeType)
+	var (
+		names   []string
+		drivers []interface{}
+	)
+	for _, f := range driver.GetFactories() {
+		names = append(names, f.Name())
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		drivers = append(drivers, map[string]interface{}{
+			"if": map[string]interface{}{
+				"properties": map[string]interface{}{
+					"driver": map[string]interface{}{"const": name},
+				},
+				"required": []string{"driver"},
+			},
+			"then": driverSchema(name),
+		})
+	}
+	node["properties"].(map[string]interface{})["driver"].(map[string]interface{})["enum"] = names
+	node["required"] = []string{"name"}
+	if len(drivers) != 0 {
+		node["allOf"] = drivers
+	}
+	root["definitions"] = map[string]interface{}{"node": node}
+
+	return json.MarshalIndent(root, "", "  ")
+}
+
+// driverSchema returns the constraints of a node using the driver called
+// name.
+func driverSchema(name string) map[string]interface{} {
+	s := make(map[string]interface{})
+	f := driver.GetFactory(name, false)
+	if needsEndpoint(f) {
+		s["required"] = []string{"endpoint"}
+	} else {
+		s["not"] = map[string]interface{}{"required": []string{"tls"}}
+	}
+
+	opts, ok := asmdriver.Options(f)
+	if ok {
+		props := make(map[string]interface{})
+		patterns := make(map[string]interface{})
+		for _, o := range opts {
+			if o.Prefix {
+				patterns["^"+strings.ReplaceAll(o.Name, ".", `\.`)+".+"] = map[string]interface{}{"type": "string", "description": o.Usage}
+				continue
+			}
+			props[o.Name] = map[string]interface{}{"type": "string", "description": o.Usage}
+		}
+		driverOpts := map[string]interface{}{
+			"type":                 "object",
+			"properties":           props,
+			"additionalProperties": false,
+		}
+		if len(patterns) != 0 {
+			driverOpts["patternProperties"] = patterns
+		}
+		s["properties"] = map[string]interface{}{"driverOpts": driverOpts}
+	}
+	return s
+}
+
+// typeSchema describes t following its json encoding.
+func typeSchema(t reflect.Type) map[string]interface{} {
+	switch t {
+	case platformsType:
+		return map[string]interface{}{
+			"type":  "array",
+			"items": map[string]interface{}{"type": "string"},
+		}
+	case durationType:
+		return map[string]interface{}{"type": "string"}
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		return typeSchema(t.Elem())
+	case reflect.Struct:
+		return structSchema(t)
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]interface{}{"type": "string"}
+		}
+		return map[string]interface{}{"type": "array", "items": elemSchema(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": elemSchema(t.Elem())}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int64, reflect.Uint, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	}
+	return map[string]interface{}{"type": "string"}
+}
+
+// elemSchema is typeSchema for the elements of lists and maps, which refer
+// to the node definition instead of repeating it.
+func elemSchema(t reflect.Type) map[string]interface{} {
+	if t == nodeType {
+		return map[string]interface{}{"$ref": "#/definitions/node"}
+	}
+	return typeSchema(t)
+}
+
+func structSchema(t reflect.Type) map[string]interface{} {
+	props := make(map[string]interface{})
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.Anonymous {
+			// embedded fields are encoded inline
+			for name, p := range structSchema(f.Type)["properties"].(map[string]interface{}) {
+				props[name] = p
+			}
+			continue
+		}
+		name := strings.Split(f.Tag.Get("json"), ",")[0]
+		if f.PkgPath != "" || name == "-" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		p := elemSchema(f.Type)
+		if d, ok := descriptions[t.Name()+"."+f.Name]; ok {
+			p["description"] = d
+		}
+		props[name] = p
+	}
+	return map[string]interface{}{
+		"type":                 "object",
+		"properties":           props,
+		"additionalProperties": false,
+	}
+}
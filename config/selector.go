@@ -0,0 +1,126 @@
+package config
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// characters with a meaning in selectors, which labels can not contain
+const selectorChars = "=!, "
+
+// Selector picks nodes by name and labels. It is built from a list of terms:
+//
+//	name       the node is called name or has the label name set to anything but false
+//	!name      the opposite of name
+//	key=value  the node has the label key set to value
+//	key!=value the node does not have the label key set to value
+//
+// A node is selected if it matches any of the positive name terms and all
+// other terms.
+type Selector struct {
+	names []string
+	terms []selectorTerm
+}
+
+type selectorTerm struct {
+	key, value string
+	hasValue   bool
+	negate     bool
+}
+
+// ParseSelector parses selector terms, each of which may hold several terms
+// separated by commas.
+func ParseSelector(terms []string) (Selector, error) {
+	var s Selector
+	for _, t := range terms {
+		for _, t := range strings.Split(t, ",") {
+			t = strings.TrimSpace(t)
+			if t == "" {
+				continue
+			}
+			var term selectorTerm
+			switch {
+			case strings.Contains(t, "!="):
+				term.key, term.value = split(t, "!=")
+				term.hasValue, term.negate = true, true
+			case strings.Contains(t, "="):
+				term.key, term.value = split(t, "=")
+				term.hasValue = true
+			case strings.HasPrefix(t, "!"):
+				term.key, term.negate = t[1:], true
+			default:
+				s.names = append(s.names, t)
+				continue
+			}
+			if term.key == "" || strings.ContainsAny(term.key, selectorChars) {
+				return s, errors.Errorf("invalid node selector %q", t)
+			}
+			s.terms = append(s.terms, term)
+		}
+	}
+	return s, nil
+}
+
+func split(s, sep string) (string, string) {
+	i := strings.Index(s, sep)
+	return s[:i], s[i+len(sep):]
+}
+
+// Empty reports whether the selector matches every node.
+func (s Selector) Empty() bool {
+	return len(s.names) == 0 && len(s.terms) == 0
+}
+
+// Match reports whether n is selected.
+func (s Selector) Match(n Node) bool {
+	if len(s.names) != 0 {
+		var ok bool
+		for _, name := range s.names {
+			if matchName(n, name) {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return false
+		}
+	}
+	for _, t := range s.terms {
+		var ok bool
+		if t.hasValue {
+			v, set := n.Labels[t.key]
+			ok = set && v == t.value
+		} else {
+			ok = matchName(n, t.key)
+		}
+		if ok == t.negate {
+			return false
+		}
+	}
+	return true
+}
+
+func matchName(n Node, name string) bool {
+	if n.Name == name {
+		return true
+	}
+	v, ok := n.Labels[name]
+	if !ok {
+		return false
+	}
+	b, err := strconv.ParseBool(v)
+	return err != nil || b
+}
+
+// Filter returns the nodes of ng selected by s.
+func (ng NodeGroup) Filter(s Selector) NodeGroup {
+	filtered := NodeGroup{Name: ng.Name}
+	for _, n := range ng.Nodes {
+		if s.Match(n) {
+			filtered.Nodes = append(filtered.Nodes, n)
+		}
+	}
+	return filtered
+}
@@ -0,0 +1,81 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseSelector(t *testing.T) {
+	for _, tc := range []struct {
+		terms []string
+		want  Selector
+		err   bool
+	}{
+		{terms: nil, want: Selector{}},
+		{terms: []string{"a", " , b "}, want: Selector{names: []string{"a", "b"}}},
+		{terms: []string{"!arm"}, want: Selector{terms: []selectorTerm{{key: "arm", negate: true}}}},
+		{terms: []string{"zone=eu"}, want: Selector{terms: []selectorTerm{{key: "zone", value: "eu", hasValue: true}}}},
+		{terms: []string{"zone="}, want: Selector{terms: []selectorTerm{{key: "zone", hasValue: true}}}},
+		{terms: []string{"url=a=b"}, want: Selector{terms: []selectorTerm{{key: "url", value: "a=b", hasValue: true}}}},
+		{terms: []string{"zone!=eu,a"}, want: Selector{
+			names: []string{"a"},
+			terms: []selectorTerm{{key: "zone", value: "eu", hasValue: true, negate: true}},
+		}},
+		{terms: []string{"=eu"}, err: true},
+		{terms: []string{"!=eu"}, err: true},
+		{terms: []string{"!"}, err: true},
+		{terms: []string{"!!a"}, err: true},
+	} {
+		s, err := ParseSelector(tc.terms)
+		if tc.err {
+			if err == nil {
+				t.Errorf("%q: expected an error", tc.terms)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: unexpected error: %s", tc.terms, err)
+			continue
+		}
+		if !reflect.DeepEqual(s, tc.want) {
+			t.Errorf("%q: expected %+v, got %+v", tc.terms, tc.want, s)
+		}
+	}
+}
+
+func TestSelectorMatch(t *testing.T) {
+	ng := NodeGroup{Nodes: []Node{
+		{Name: "a", Labels: map[string]string{"zone": "eu", "arm": "true"}},
+		{Name: "b", Labels: map[string]string{"zone": "us", "arm": "false"}},
+		{Name: "c", Labels: map[string]string{"gpu": "nvidia"}},
+		{Name: "d"},
+	}}
+	for _, tc := range []struct {
+		terms []string
+		want  []string
+	}{
+		{nil, []string{"a", "b", "c", "d"}},
+		{[]string{"a", "c"}, []string{"a", "c"}},
+		{[]string{"arm"}, []string{"a"}},
+		{[]string{"!arm"}, []string{"b", "c", "d"}},
+		{[]string{"gpu"}, []string{"c"}},
+		{[]string{"!a"}, []string{"b", "c", "d"}},
+		{[]string{"zone=eu"}, []string{"a"}},
+		{[]string{"zone!=eu"}, []string{"b", "c", "d"}},
+		{[]string{"zone=eu", "zone=us"}, nil},
+		{[]string{"a,b", "zone!=eu"}, []string{"b"}},
+		{[]string{"b", "!arm"}, []string{"b"}},
+	} {
+		s, err := ParseSelector(tc.terms)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, n := range ng.Filter(s).Nodes {
+			got = append(got, n.Name)
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%q: expected %v, got %v", tc.terms, tc.want, got)
+		}
+	}
+}
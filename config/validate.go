@@ -0,0 +1,269 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/containerd/containerd/platforms"
+	"github.com/docker/buildx/driver"
+
+	asmdriver "github.com/robertgzr/asm/driver"
+)
+
+// drivers that talk to buildkit through the docker API of the node endpoint,
+// drivers connecting to the endpoint themselves are asmdriver.EndpointFactory
+var endpointDrivers = map[string]bool{
+	"docker":           true,
+	"docker-container": true,
+}
+
+// needsEndpoint reports whether the nodes of the driver f need an endpoint.
+func needsEndpoint(f driver.Factory) bool {
+	_, ok := f.(asmdriver.EndpointFactory)
+	return ok || endpointDrivers[f.Name()]
+}
+
+// ValidationError is a problem found in a config file.
+type ValidationError struct {
+	Pos Position
+	Msg string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Pos, e.Msg)
+}
+
+// ValidationErrors is the list of problems returned by Validate.
+type ValidationErrors []*ValidationError
+
+func (es ValidationErrors) Error() string {
+	msgs := make([]string, len(es))
+	for i, e := range es {
+		msgs[i] = e.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// Validate checks the config for semantic errors: unknown drivers and
+// driver options, duplicate node names, missing endpoints, invalid
+// platforms and profiles changing unknown nodes.
+func (c Config) Validate() error {
+	return c.validate(false)
+}
+
+// ValidateAll is like Validate, but also checks that the files referenced by
+// the nodes of every group exist. Missing files only fail the nodes using
+// them when building, so this is left to `asm config validate`.
+func (c Config) ValidateAll() error {
+	return c.validate(true)
+}
+
+func (c Config) validate(files bool) error {
+	var errs ValidationErrors
+	if c.Default != "" {
+		if _, err := c.Group(c.Default); err != nil {
+			errs = append(errs, &ValidationError{Pos: c.defaultPos, Msg: err.Error()})
+		}
+	}
+	for _, ng := range c.AllGroups() {
+		errs = append(errs, ng.validate(files)...)
+	}
+	errs = append(errs, c.validateProfiles()...)
+	if len(errs) == 0 {
+		return nil
+	}
+	errs.sortByPos()
+	return errs
+}
+
+// sortByPos orders es by file and position.
+func (es ValidationErrors) sortByPos() {
+	sort.SliceStable(es, func(i, j int) bool {
+		a, b := es[i].Pos, es[j].Pos
+		if a.File != b.File {
+			return a.File < b.File
+		}
+		if a.Line != b.Line {
+			return a.Line < b.Line
+		}
+		return a.Column < b.Column
+	})
+}
+
+func (ng NodeGroup) validate(files bool) (errs ValidationErrors) {
+	seen := make(map[string]Node)
+	for _, n := range ng.Nodes {
+		if first, ok := seen[n.Name]; ok && n.Name != "" {
+			errs = append(errs, &ValidationError{
+				Pos: n.Pos("name"),
+				Msg: fmt.Sprintf("duplicate node name %q in group %s, first declared at %s", n.Name, ng.Name, first.Pos("name")),
+			})
+		} else {
+			seen[n.Name] = n
+		}
+		errs = append(errs, n.validate(files)...)
+	}
+	return errs
+}
+
+func (n Node) validate(files bool) (errs ValidationErrors) {
+	errorf := func(field, format string, args ...interface{}) {
+		errs = append(errs, &ValidationError{Pos: n.Pos(field), Msg: fmt.Sprintf(format, args...)})
+	}
+
+	if n.Name == "" {
+		errorf("", "node has no name")
+	}
+
+	for k := range n.Labels {
+		if k == "" || strings.ContainsAny(k, selectorChars) {
+			errorf("labels."+k, "invalid label %q of node %q, labels can not be empty or contain any of %q", k, n.Name, selectorChars)
+		}
+	}
+
+	for i, p := range n.Platforms {
+		field := fmt.Sprintf("platforms[%d]", i)
+		if p.OS == "" || p.Architecture == "" {
+			errorf(field, "platform of node %q needs both os and architecture", n.Name)
+		} else if _, err := platforms.Parse(platforms.Format(p)); err != nil {
+			errorf(field, "invalid platform of node %q: %s", n.Name, err)
+		}
+	}
+
+	checkFile := func(field, fn string) {
+		if !files {
+			return
+		}
+		f, err := os.Open(fn)
+		if err != nil {
+			errorf(field, "node %q: %s", n.Name, err)
+			return
+		}
+		f.Close()
+	}
+	if t := n.TLS; t != nil {
+		for field, fn := range map[string]string{"ca": t.CA, "cert": t.Cert, "key": t.Key} {
+			if fn != "" {
+				checkFile("tls."+field, fn)
+			}
+		}
+		if (t.Cert == "") != (t.Key == "") {
+			errorf("tls", "node %q needs both tls cert and key for client authentication", n.Name)
+		}
+	}
+	if s := n.SSH; s != nil {
+		for field, fn := range map[string]string{"identityFile": s.IdentityFile, "knownHosts": s.KnownHosts} {
+			if fn != "" {
+				checkFile("ssh."+field, fn)
+			}
+		}
+		if s.Port < 0 || s.Port > 65535 {
+			errorf("ssh.port", "invalid ssh port %d of node %q", s.Port, n.Name)
+		}
+		switch s.HostKeyCheck {
+		case "", "yes", "no", "accept-new":
+		default:
+			errorf("ssh.hostKeyCheck", "invalid hostKeyCheck %q of node %q, expected yes, no or accept-new", s.HostKeyCheck, n.Name)
+		}
+		if !strings.HasPrefix(n.Endpoint, "ssh://") {
+			errorf("ssh", "node %q: ssh is only supported for ssh:// endpoints", n.Name)
+		}
+	}
+	if strings.HasPrefix(n.Endpoint, "ssh://") && n.TLS != nil {
+		errorf("tls", "node %q: tls is not supported for ssh:// endpoints", n.Name)
+	}
+	if k := n.Kubernetes; k != nil {
+		if k.Kubeconfig != "" {
+			checkFile("kubernetes.kubeconfig", k.Kubeconfig)
+		}
+		if k.Replicas < 0 {
+			errorf("kubernetes.replicas", "replicas of node %q can not be negative", n.Name)
+		}
+		if n.Driver != "" && n.Driver != "kubernetes" {
+			errorf("kubernetes", "node %q: kubernetes is only supported by the kubernetes driver", n.Name)
+		}
+		for field, set := range map[string]bool{"namespace": k.Namespace != "", "replicas": k.Replicas != 0} {
+			if _, ok := n.DriverOpts[field]; ok && set {
+				errorf("driverOpts."+field, "node %q sets %s in both kubernetes and driverOpts", n.Name, field)
+			}
+		}
+	}
+	if n.Driver == "kubernetes" && n.Endpoint != "" {
+		errorf("endpoint", "node %q: the kubernetes driver connects through the kubeconfig, not an endpoint", n.Name)
+	}
+	if n.Driver == "containerd" && n.Endpoint != "" {
+		errorf("endpoint", "node %q: the containerd driver connects to the address driver option, not an endpoint", n.Name)
+	}
+	if n.Driver == "remote" {
+		// buildkitd is run by someone else, there is nothing to pass these to
+		for _, f := range []struct {
+			field string
+			set   bool
+		}{
+			{"flags", len(n.Flags) != 0},
+			{"files", len(n.Files) != 0},
+			{"buildkitConfig", n.BuildkitConfig != ""},
+		} {
+			if f.set {
+				errorf(f.field, "node %q: %s is not supported by the remote driver, which does not start buildkitd", n.Name, f.field)
+			}
+		}
+	}
+	if n.ConnectTimeout < 0 {
+		errorf("connectTimeout", "connect timeout of node %q can not be negative", n.Name)
+	}
+	if n.Retries != nil && *n.Retries < 0 {
+		errorf("retries", "retries of node %q can not be negative", n.Name)
+	}
+	if n.BuildkitConfig != "" {
+		checkFile("buildkitConfig", n.BuildkitConfig)
+	}
+
+	if n.Driver == "" {
+		errorf("", "node %q has no driver", n.Name)
+		return errs
+	}
+	f := driver.GetFactory(n.Driver, false)
+	if f == nil {
+		var names []string
+		for _, f := range driver.GetFactories() {
+			names = append(names, f.Name())
+		}
+		errorf("driver", "unknown driver %q for node %q, available: %s", n.Driver, n.Name, strings.Join(names, ", "))
+		return errs
+	}
+
+	if needsEndpoint(f) {
+		if n.Endpoint == "" {
+			errorf("", "node %q needs an endpoint for the %s driver", n.Name, n.Driver)
+		} else if ef, ok := f.(asmdriver.EndpointFactory); ok {
+			if err := ef.ValidateEndpoint(n.Endpoint); err != nil {
+				errorf("endpoint", "node %q: %s", n.Name, err)
+			}
+		}
+	} else if n.TLS != nil {
+		errorf("tls", "node %q: tls is not supported by the %s driver", n.Name, n.Driver)
+	}
+
+	opts, ok := asmdriver.Options(f)
+	if !ok {
+		return errs
+	}
+	keys := make([]string, 0, len(n.DriverOpts))
+	for k := range n.DriverOpts {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+next:
+	for _, k := range keys {
+		for _, o := range opts {
+			if o.Match(k) {
+				continue next
+			}
+		}
+		errorf("driverOpts."+k, "invalid driver option %q for the %s driver of node %q", k, n.Driver, n.Name)
+	}
+	return errs
+}
@@ -0,0 +1,69 @@
+package config
+
+import (
+	"errors"
+	"testing"
+
+	_ "github.com/robertgzr/asm/driver/remote"
+)
+
+func TestValidateDuplicateNodes(t *testing.T) {
+	cfg := mustParse(t, "/asm.yml", `version: 2
+nodes:
+  - name: a
+    driver: docker
+    endpoint: unix:///var/run/docker.sock
+  - name: a
+    driver: nope
+`)
+	err := cfg.Validate()
+	var verrs ValidationErrors
+	if !errors.As(err, &verrs) {
+		t.Fatalf("expected validation errors, got %v", err)
+	}
+	want := []string{
+		`/asm.yml:6:11: duplicate node name "a" in group default, first declared at /asm.yml:3:11`,
+		`/asm.yml:7:13: unknown driver "nope" for node "a", available: docker, remote`,
+	}
+	if len(verrs) != len(want) {
+		t.Fatalf("expected %d errors, got %d:\n%s", len(want), len(verrs), verrs)
+	}
+	for i, e := range verrs {
+		if e.Error() != want[i] {
+			t.Errorf("expected error\n%s\ngot\n%s", want[i], e)
+		}
+	}
+}
+
+func TestValidateRemote(t *testing.T) {
+	cfg := mustParse(t, "/asm.yml", `version: 2
+nodes:
+  - name: farm
+    driver: remote
+    endpoint: tcp://buildkitd:1234
+    flags: [--debug]
+    buildkitConfig: buildkitd.toml
+    files: {buildkitd.toml: ""}
+  - name: local
+    driver: remote
+    endpoint: unix:///run/buildkit/buildkitd.sock
+`)
+	err := cfg.Validate()
+	var verrs ValidationErrors
+	if !errors.As(err, &verrs) {
+		t.Fatalf("expected validation errors, got %v", err)
+	}
+	want := []string{
+		`/asm.yml:6:12: node "farm": flags is not supported by the remote driver, which does not start buildkitd`,
+		`/asm.yml:7:21: node "farm": buildkitConfig is not supported by the remote driver, which does not start buildkitd`,
+		`/asm.yml:8:12: node "farm": files is not supported by the remote driver, which does not start buildkitd`,
+	}
+	if len(verrs) != len(want) {
+		t.Fatalf("expected %d errors, got %d:\n%s", len(want), len(verrs), verrs)
+	}
+	for i, e := range verrs {
+		if e.Error() != want[i] {
+			t.Errorf("expected error\n%s\ngot\n%s", want[i], e)
+		}
+	}
+}
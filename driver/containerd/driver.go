@@ -0,0 +1,506 @@
+package containerd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/containerd/containerd"
+	"github.com/containerd/containerd/cio"
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/mount"
+	"github.com/containerd/containerd/namespaces"
+	"github.com/containerd/containerd/oci"
+	"github.com/containerd/containerd/snapshots"
+	"github.com/docker/buildx/driver"
+	"github.com/docker/buildx/driver/bkimage"
+	"github.com/docker/buildx/util/confutil"
+	"github.com/docker/buildx/util/progress"
+	"github.com/moby/buildkit/client"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	asmdriver "github.com/robertgzr/asm/driver"
+)
+
+var (
+	rootfsSuffix = "_rootfs"
+	stateSuffix  = "_state"
+)
+
+// time buildkitd gets to shut down before it is killed
+const stopTimeout = 10 * time.Second
+
+// Driver runs buildkitd as a containerd task. Its state lives in a separate
+// snapshot mounted at /var/lib/buildkit, which is kept when the container is
+// removed unless the volumes are removed as well.
+type Driver struct {
+	factory driver.Factory
+	driver.InitConfig
+	image       string
+	address     string
+	namespace   string
+	snapshotter string
+}
+
+func (d *Driver) Factory() driver.Factory {
+	return d.factory
+}
+
+// connect returns a containerd client and ctx in the namespace of d.
+func (d *Driver) connect(ctx context.Context) (*containerd.Client, context.Context, error) {
+	c, err := containerd.New(d.address, containerd.WithDefaultNamespace(d.namespace))
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "failed to connect to containerd at %s", d.address)
+	}
+	return c, namespaces.WithNamespace(ctx, d.namespace), nil
+}
+
+func (d *Driver) Bootstrap(ctx context.Context, l progress.Logger) error {
+	return progress.Wrap("[internal] booting buildkit", l, func(sub progress.SubLogger) error {
+		info, err := d.Info(ctx)
+		if err != nil {
+			return err
+		}
+
+		if info.Status == driver.Running {
+			return nil
+		}
+
+		c, ctx, err := d.connect(ctx)
+		if err != nil {
+			return err
+		}
+		defer c.Close()
+
+		if info.Status == driver.Inactive {
+			if err := d.create(ctx, c, sub); err != nil {
+				return err
+			}
+		}
+
+		return sub.Wrap("starting container", func() error {
+			if err := d.start(ctx, c); err != nil {
+				return err
+			}
+			return d.wait(ctx)
+		})
+	})
+}
+
+func (d *Driver) create(ctx context.Context, c *containerd.Client, l progress.SubLogger) error {
+	imageName := "docker.io/" + bkimage.DefaultImage
+	if d.image != "" {
+		imageName = d.image
+	}
+	snapshotter := d.snapshotter
+	if snapshotter == "" {
+		snapshotter = containerd.DefaultSnapshotter
+	}
+
+	image, err := c.GetImage(ctx, imageName)
+	if errdefs.IsNotFound(err) {
+		err = l.Wrap("pulling image "+imageName, func() error {
+			var err error
+			image, err = c.Pull(ctx, imageName, containerd.WithPullUnpack, containerd.WithPullSnapshotter(snapshotter))
+			return err
+		})
+	} else if err == nil {
+		logrus.WithField("name", d.Name).Debugf("using local image %s", imageName)
+		// the image may have been pulled for another snapshotter
+		if err := image.Unpack(ctx, snapshotter); err != nil {
+			return errors.Wrapf(err, "failed to unpack image %s", imageName)
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	var stateMount specs.Mount
+	if err := l.Wrap("preparing state snapshot "+d.Name+stateSuffix, func() error {
+		var err error
+		stateMount, err = d.prepareState(ctx, c.SnapshotService(snapshotter))
+		return err
+	}); err != nil {
+		return err
+	}
+
+	specOpts := []oci.SpecOpts{
+		oci.WithImageConfigArgs(image, d.BuildkitFlags),
+		oci.WithPrivileged,
+		oci.WithAllDevicesAllowed,
+		oci.WithHostDevices,
+		oci.WithHostNamespace(specs.NetworkNamespace),
+		oci.WithHostHostsFile,
+		oci.WithHostResolvconf,
+		oci.WithMounts([]specs.Mount{stateMount}),
+	}
+
+	return l.Wrap("creating container "+d.Name, func() error {
+		container, err := c.NewContainer(ctx, d.Name,
+			containerd.WithImage(image),
+			containerd.WithSnapshotter(snapshotter),
+			containerd.WithNewSnapshot(d.Name+rootfsSuffix, image),
+			containerd.WithNewSpec(specOpts...),
+		)
+		if err != nil {
+			return errors.Wrap(err, "failed to create container")
+		}
+		if len(d.Files) == 0 {
+			return nil
+		}
+		if err := d.writeFiles(ctx, c.SnapshotService(snapshotter)); err != nil {
+			container.Delete(ctx, containerd.WithSnapshotCleanup)
+			return err
+		}
+		return nil
+	})
+}
+
+// prepareState creates the snapshot holding the buildkit state, unless it
+// exists from an earlier container, and returns its mount.
+func (d *Driver) prepareState(ctx context.Context, sn snapshots.Snapshotter) (specs.Mount, error) {
+	key := d.Name + stateSuffix
+	mounts, err := sn.Mounts(ctx, key)
+	if errdefs.IsNotFound(err) {
+		// the snapshot is not referenced by the container, so it is kept
+		// from garbage collection explicitly
+		mounts, err = sn.Prepare(ctx, key, "", snapshots.WithLabels(map[string]string{
+			"containerd.io/gc.root": time.Now().UTC().Format(time.RFC3339),
+		}))
+	}
+	if err != nil {
+		return specs.Mount{}, err
+	}
+	// a snapshot without parent is a single bind mount
+	if len(mounts) != 1 {
+		return specs.Mount{}, errors.Errorf("unexpected mounts of state snapshot %s: %v", key, mounts)
+	}
+	return specs.Mount{
+		Destination: confutil.DefaultBuildKitStateDir,
+		Type:        mounts[0].Type,
+		Source:      mounts[0].Source,
+		Options:     mounts[0].Options,
+	}, nil
+}
+
+// writeFiles writes the buildkit config files to the rootfs of the container.
+func (d *Driver) writeFiles(ctx context.Context, sn snapshots.Snapshotter) error {
+	mounts, err := sn.Mounts(ctx, d.Name+rootfsSuffix)
+	if err != nil {
+		return err
+	}
+	return mount.WithTempMount(ctx, mounts, func(root string) error {
+		for name, b := range d.Files {
+			fn := filepath.Join(root, confutil.DefaultBuildKitConfigDir, filepath.Clean("/"+name))
+			if err := os.MkdirAll(filepath.Dir(fn), 0755); err != nil {
+				return err
+			}
+			if err := ioutil.WriteFile(fn, b, 0644); err != nil {
+				return errors.Wrap(err, "failed to write buildkit config")
+			}
+		}
+		return nil
+	})
+}
+
+func (d *Driver) start(ctx context.Context, c *containerd.Client) error {
+	container, err := c.LoadContainer(ctx, d.Name)
+	if err != nil {
+		return err
+	}
+	// a task that exited has to be deleted before the next one is created
+	if task, err := container.Task(ctx, nil); err == nil {
+		if _, err := task.Delete(ctx, containerd.WithProcessKill); err != nil {
+			return err
+		}
+	} else if !errdefs.IsNotFound(err) {
+		return err
+	}
+
+	task, err := container.NewTask(ctx, cio.NullIO)
+	if err != nil {
+		return errors.Wrap(err, "failed to create task")
+	}
+	if err := task.Start(ctx); err != nil {
+		task.Delete(ctx, containerd.WithProcessKill)
+		return errors.Wrap(err, "failed to start task")
+	}
+	return nil
+}
+
+// wait blocks until buildkitd answers.
+func (d *Driver) wait(ctx context.Context) error {
+	try := 1
+	for {
+		c, err := d.Client(ctx)
+		if err == nil {
+			_, err = c.ListWorkers(ctx)
+			c.Close()
+		}
+		if err == nil {
+			return nil
+		}
+		if try > 15 {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Duration(try*120) * time.Millisecond):
+			try++
+		}
+	}
+}
+
+func (d *Driver) Info(ctx context.Context) (*driver.Info, error) {
+	c, ctx, err := d.connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+
+	container, err := c.LoadContainer(ctx, d.Name)
+	if errdefs.IsNotFound(err) {
+		logrus.Debug("Container not found, marking driver inactive")
+		return &driver.Info{
+			Status: driver.Inactive,
+		}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	task, err := container.Task(ctx, nil)
+	if errdefs.IsNotFound(err) {
+		logrus.Debug("Container found without task, marking driver stopped")
+		return &driver.Info{
+			Status: driver.Stopped,
+		}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	status, err := task.Status(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if status.Status != containerd.Running {
+		logrus.Debugf("Task is %s, marking driver stopped", status.Status)
+		return &driver.Info{
+			Status: driver.Stopped,
+		}, nil
+	}
+	return &driver.Info{
+		Status: driver.Running,
+	}, nil
+}
+
+func (d *Driver) Stop(ctx context.Context, force bool) error {
+	c, ctx, err := d.connect(ctx)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	container, err := c.LoadContainer(ctx, d.Name)
+	if errdefs.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	task, err := container.Task(ctx, nil)
+	if errdefs.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := stopTask(ctx, task); err != nil {
+		return errors.Wrap(err, "failed to stop task")
+	}
+	_, err = task.Delete(ctx)
+	return err
+}
+
+// stopTask sends SIGTERM to the task and kills it if it does not exit in
+// time.
+func stopTask(ctx context.Context, task containerd.Task) error {
+	status, err := task.Status(ctx)
+	if err != nil {
+		return err
+	}
+	if status.Status == containerd.Stopped || status.Status == containerd.Created {
+		return nil
+	}
+	exitC, err := task.Wait(ctx)
+	if err != nil {
+		return err
+	}
+	if err := task.Kill(ctx, syscall.SIGTERM); err != nil && !errdefs.IsNotFound(err) {
+		return err
+	}
+	select {
+	case <-exitC:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(stopTimeout):
+	}
+	if err := task.Kill(ctx, syscall.SIGKILL); err != nil && !errdefs.IsNotFound(err) {
+		return err
+	}
+	<-exitC
+	return nil
+}
+
+func (d *Driver) Rm(ctx context.Context, force bool, rmVolume bool) error {
+	c, ctx, err := d.connect(ctx)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	container, err := c.LoadContainer(ctx, d.Name)
+	if err == nil {
+		if task, err := container.Task(ctx, nil); err == nil {
+			// a running task can not be deleted
+			if err := stopTask(ctx, task); err != nil {
+				return errors.Wrap(err, "failed to stop task")
+			}
+			var opts []containerd.ProcessDeleteOpts
+			if force {
+				opts = append(opts, containerd.WithProcessKill)
+			}
+			if _, err := task.Delete(ctx, opts...); err != nil {
+				return errors.Wrap(err, "failed to remove task")
+			}
+		} else if !errdefs.IsNotFound(err) {
+			return err
+		}
+		if err := container.Delete(ctx, containerd.WithSnapshotCleanup); err != nil {
+			return errors.Wrap(err, "failed to remove container")
+		}
+	} else if !errdefs.IsNotFound(err) {
+		return err
+	}
+
+	if rmVolume {
+		return d.rmState(ctx, c)
+	}
+	return nil
+}
+
+// rmState removes the snapshot holding the buildkit state, if it exists.
+func (d *Driver) rmState(ctx context.Context, c *containerd.Client) error {
+	snapshotter := d.snapshotter
+	if snapshotter == "" {
+		snapshotter = containerd.DefaultSnapshotter
+	}
+	err := c.SnapshotService(snapshotter).Remove(ctx, d.Name+stateSuffix)
+	if err != nil && !errdefs.IsNotFound(err) {
+		return errors.Wrap(err, "failed to remove state snapshot")
+	}
+	return nil
+}
+
+// exec runs command in the buildkit container with its stdio connected to
+// the returned conn. The process is removed once it exits.
+func (d *Driver) exec(ctx context.Context, command []string) (net.Conn, error) {
+	c, nsctx, err := d.connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	container, err := c.LoadContainer(nsctx, d.Name)
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	task, err := container.Task(nsctx, nil)
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	spec, err := container.Spec(nsctx)
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	pspec := *spec.Process
+	pspec.Args = command
+	pspec.Terminal = false
+
+	stdinR, stdinW := io.Pipe()
+	stdoutR, stdoutW := io.Pipe()
+	stderr := logrus.WithField("name", d.Name).WriterLevel(logrus.DebugLevel)
+	id := fmt.Sprintf("dial-stdio-%d", time.Now().UnixNano())
+	p, err := task.Exec(nsctx, id, &pspec, cio.NewCreator(cio.WithStreams(stdinR, stdoutW, stderr)))
+	if err != nil {
+		stderr.Close()
+		c.Close()
+		return nil, err
+	}
+
+	// the process outlives ctx, it ends when the connection is closed
+	bgctx := namespaces.WithNamespace(context.Background(), d.namespace)
+	exitC, err := p.Wait(bgctx)
+	if err != nil {
+		p.Delete(bgctx)
+		c.Close()
+		return nil, err
+	}
+	if err := p.Start(nsctx); err != nil {
+		p.Delete(bgctx)
+		c.Close()
+		return nil, err
+	}
+	go func() {
+		defer c.Close()
+		status := <-exitC
+		if code := status.ExitCode(); code != 0 {
+			logrus.WithField("name", d.Name).Debugf("%s exited with %d", strings.Join(command, " "), code)
+		}
+		stdinR.Close()
+		stdoutW.Close()
+		stderr.Close()
+		if _, err := p.Delete(bgctx); err != nil {
+			logrus.WithField("name", d.Name).Debugf("removing exec process: %s", err)
+		}
+	}()
+	return asmdriver.NewStdioConn(ctx, stdinW, stdoutR)
+}
+
+func (d *Driver) Client(ctx context.Context) (*client.Client, error) {
+	conn, err := d.exec(ctx, []string{"buildctl", "dial-stdio"})
+	if err != nil {
+		return nil, err
+	}
+	return client.New(ctx, "", client.WithContextDialer(func(_ context.Context, addr string) (net.Conn, error) {
+		return conn, nil
+	}))
+}
+
+func (d *Driver) Features() map[driver.Feature]bool {
+	return map[driver.Feature]bool{
+		driver.OCIExporter:    true,
+		driver.DockerExporter: true,
+		driver.CacheExport:    true,
+		driver.MultiPlatform:  true,
+	}
+}
+
+func (d *Driver) IsMobyDriver() bool {
+	return false
+}
+
+func (d *Driver) Config() driver.InitConfig {
+	return d.InitConfig
+}
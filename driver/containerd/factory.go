@@ -0,0 +1,75 @@
+package containerd
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/containerd/containerd/defaults"
+	"github.com/docker/buildx/driver"
+	dockerclient "github.com/docker/docker/client"
+
+	asmdriver "github.com/robertgzr/asm/driver"
+)
+
+// defaultNamespace is the containerd namespace the buildkit containers are
+// created in, keeping them apart from those of docker ("moby") or k8s.io.
+const defaultNamespace = "asm"
+
+func init() {
+	driver.Register(&factory{})
+}
+
+type factory struct{}
+
+func (*factory) Name() string {
+	return "containerd"
+}
+
+func (*factory) Usage() string {
+	return "containerd"
+}
+
+func (*factory) Priority(_ context.Context, _ dockerclient.APIClient) int {
+	return 1
+}
+
+func (f *factory) New(ctx context.Context, cfg driver.InitConfig) (driver.Driver, error) {
+	d := &Driver{
+		factory:    f,
+		InitConfig: cfg,
+		address:    defaults.DefaultAddress,
+		namespace:  defaultNamespace,
+	}
+	if addr := os.Getenv("CONTAINERD_ADDRESS"); addr != "" {
+		d.address = addr
+	}
+	for k, v := range cfg.DriverOpts {
+		switch k {
+		case "image":
+			d.image = v
+		case "address":
+			d.address = v
+		case "namespace":
+			d.namespace = v
+		case "snapshotter":
+			d.snapshotter = v
+		default:
+			return nil, fmt.Errorf("invalid driver option %s for containerd driver", k)
+		}
+	}
+	return d, nil
+}
+
+func (*factory) Options() []asmdriver.Option {
+	return []asmdriver.Option{
+		{Name: "image", Usage: "buildkit image to use"},
+		{Name: "address", Usage: "containerd socket, defaults to $CONTAINERD_ADDRESS or " + defaults.DefaultAddress},
+		{Name: "namespace", Usage: "containerd namespace of the buildkit container, defaults to " + defaultNamespace},
+		{Name: "snapshotter", Usage: "containerd snapshotter of the buildkit container and its state"},
+	}
+}
+
+func (*factory) AllowsInstances() bool {
+	return true
+}
@@ -0,0 +1,25 @@
+package internal
+
+import (
+	"context"
+	"crypto/tls"
+
+	"github.com/docker/buildx/driver"
+)
+
+// Endpoint is the address of a node for drivers that connect to it
+// themselves instead of through a docker client.
+type Endpoint struct {
+	Address string
+	// TLS is nil for plain connections
+	TLS *tls.Config
+}
+
+// EndpointFactory is implemented by driver factories that connect to the
+// node endpoint themselves, no docker client is created for their nodes.
+type EndpointFactory interface {
+	driver.Factory
+	// ValidateEndpoint checks the endpoint of a node without connecting
+	ValidateEndpoint(endpoint string) error
+	NewWithEndpoint(ctx context.Context, cfg driver.InitConfig, ep Endpoint) (driver.Driver, error)
+}
@@ -0,0 +1,65 @@
+package internal
+
+import (
+	"strings"
+
+	"github.com/docker/buildx/driver"
+)
+
+// Option describes a key accepted in the driver options of a node.
+type Option struct {
+	Name  string
+	Usage string
+	// Prefix is set for options that take a suffix, like `env.FOO`
+	Prefix bool
+}
+
+// Match reports whether the driver option key is covered by o.
+func (o Option) Match(key string) bool {
+	if o.Prefix {
+		return strings.HasPrefix(key, o.Name) && len(key) > len(o.Name)
+	}
+	return key == o.Name
+}
+
+// OptionsFactory is implemented by driver factories that can list the
+// driver options they accept.
+type OptionsFactory interface {
+	driver.Factory
+	Options() []Option
+}
+
+// options accepted by the buildx drivers, which don't describe themselves
+var builtinOptions = map[string][]Option{
+	"docker": {},
+	"docker-container": {
+		{Name: "image", Usage: "buildkit image to use"},
+		{Name: "network", Usage: "network mode of the buildkit container"},
+		{Name: "cgroup-parent", Usage: "cgroup parent of the buildkit container"},
+		{Name: "env.", Usage: "environment variable to set in the buildkit container", Prefix: true},
+	},
+	"kubernetes": {
+		{Name: "image", Usage: "buildkit image to use"},
+		{Name: "namespace", Usage: "namespace to deploy buildkit into"},
+		{Name: "replicas", Usage: "number of buildkit pods"},
+		{Name: "requests.cpu", Usage: "requested cpu of the buildkit pods"},
+		{Name: "requests.memory", Usage: "requested memory of the buildkit pods"},
+		{Name: "limits.cpu", Usage: "cpu limit of the buildkit pods"},
+		{Name: "limits.memory", Usage: "memory limit of the buildkit pods"},
+		{Name: "rootless", Usage: "run buildkit rootless"},
+		{Name: "nodeselector", Usage: "kubernetes node selector of the buildkit pods"},
+		{Name: "loadbalance", Usage: "pod selection strategy, sticky or random"},
+		{Name: "qemu.install", Usage: "install qemu emulation into the buildkit pods"},
+		{Name: "qemu.image", Usage: "qemu image to use"},
+	},
+}
+
+// Options returns the driver options accepted by f, ok is false if they are
+// not known.
+func Options(f driver.Factory) (opts []Option, ok bool) {
+	if of, ok := f.(OptionsFactory); ok {
+		return of.Options(), true
+	}
+	opts, ok = builtinOptions[f.Name()]
+	return opts, ok
+}
@@ -141,27 +141,39 @@ func (d *Driver) Rm(ctx context.Context, force bool, rmVolume bool) error {
 	if err != nil {
 		return err
 	}
-	if info.Status == driver.Inactive {
-		return nil
+	if info.Status != driver.Inactive {
+		if err := podman.RemoveContainer(d.Name, force); err != nil {
+			return err
+		}
+	}
+	if rmVolume {
+		return d.rmVolume()
 	}
-	containers, err := podman.GetContainers(d.Name)
+	return nil
+}
+
+// rmVolume removes the volume holding the buildkit state, if it exists.
+func (d *Driver) rmVolume() error {
+	volume := d.Name + volumeStateSuffix
+	exitCode, err := shell.RunWithExitCode("podman", nil, nil, nil, "--log-level", podman.LogLevel.String(), "volume", "exists", volume)
 	if err != nil {
 		return err
 	}
-	if err := podman.RemoveContainer(d.Name, force); err != nil {
-		return err
+	if exitCode != 0 {
+		return nil
+	}
+
+	rmArgs := []string{
+		"--log-level", podman.LogLevel.String(),
+		"volume",
+		"rm",
+		volume,
+	}
+
+	var stderr strings.Builder
+	if err := shell.Run("podman", nil, nil, &stderr, rmArgs...); err != nil {
+		return fmt.Errorf("failed to remove volume: %s", stderr.String())
 	}
-	logrus.Warnf("%+v", containers[0]["Mounts"])
-	// for _, v := range containers[0]["Mounts"] {
-	// 	logrus.Warnf("%+v", v)
-	// 	m, ok := v.(map[string]interface{})
-	// 	if !ok {
-	// 		panic("not a volume")
-	// 	}
-	// 	if m["Type"] == "volume" && m["Source"] == d.Name+volumeStateSuffix && rmVolume {
-	// 		return errors.New("volume removal not implemented")
-	// 	}
-	// }
 	return nil
 }
 
@@ -7,6 +7,8 @@ import (
 
 	"github.com/docker/buildx/driver"
 	dockerclient "github.com/docker/docker/client"
+
+	asmdriver "github.com/robertgzr/asm/driver"
 )
 
 func init() {
@@ -56,6 +58,12 @@ func (f *factory) New(ctx context.Context, cfg driver.InitConfig) (driver.Driver
 	return d, nil
 }
 
+func (*factory) Options() []asmdriver.Option {
+	return []asmdriver.Option{
+		{Name: "image", Usage: "buildkit image to use"},
+	}
+}
+
 func (*factory) AllowsInstances() bool {
 	return true
 }
@@ -0,0 +1,48 @@
+package podman
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+
+	"github.com/containers/toolbox/pkg/podman"
+	"github.com/containers/toolbox/pkg/shell"
+	"github.com/pkg/errors"
+)
+
+// HostInfo is the part of `podman info` describing the host.
+type HostInfo struct {
+	Host struct {
+		Arch     string `json:"arch"`
+		OS       string `json:"os"`
+		Security struct {
+			Rootless bool `json:"rootless"`
+		} `json:"security"`
+		// RemoteSocket is the socket of the podman API service
+		RemoteSocket struct {
+			Path   string `json:"path"`
+			Exists bool   `json:"exists"`
+		} `json:"remoteSocket"`
+	} `json:"host"`
+	Version struct {
+		Version string `json:"Version"`
+	} `json:"version"`
+}
+
+// Info runs `podman info` on the local host.
+func Info() (*HostInfo, error) {
+	var stdout, stderr bytes.Buffer
+	args := []string{"--log-level", podman.LogLevel.String(), "info", "--format", "json"}
+	if err := shell.Run("podman", nil, &stdout, &stderr, args...); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, errors.Wrap(err, msg)
+		}
+		return nil, err
+	}
+
+	var info HostInfo
+	if err := json.Unmarshal(stdout.Bytes(), &info); err != nil {
+		return nil, errors.Wrap(err, "parsing podman info")
+	}
+	return &info, nil
+}
@@ -0,0 +1,113 @@
+package remote
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/url"
+	"time"
+
+	"github.com/docker/buildx/driver"
+	"github.com/docker/buildx/util/progress"
+	"github.com/moby/buildkit/client"
+	"github.com/pkg/errors"
+
+	asmdriver "github.com/robertgzr/asm/driver"
+)
+
+// Driver connects to a buildkitd that is managed elsewhere, it cannot start,
+// stop or remove it.
+type Driver struct {
+	factory driver.Factory
+	driver.InitConfig
+	endpoint asmdriver.Endpoint
+}
+
+func (d *Driver) Factory() driver.Factory {
+	return d.factory
+}
+
+func (d *Driver) Bootstrap(ctx context.Context, l progress.Logger) error {
+	return progress.Wrap("[internal] connecting to buildkitd", l, func(progress.SubLogger) error {
+		return d.ping(ctx)
+	})
+}
+
+func (d *Driver) Info(ctx context.Context) (*driver.Info, error) {
+	if err := d.ping(ctx); err != nil {
+		return &driver.Info{Status: driver.Inactive}, nil
+	}
+	return &driver.Info{Status: driver.Running}, nil
+}
+
+// ping checks that buildkitd answers.
+func (d *Driver) ping(ctx context.Context) error {
+	c, err := d.Client(ctx)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	_, err = c.ListWorkers(ctx)
+	return err
+}
+
+func (d *Driver) Stop(ctx context.Context, force bool) error {
+	return nil
+}
+
+func (d *Driver) Rm(ctx context.Context, force bool, rmVolume bool) error {
+	return nil
+}
+
+func (d *Driver) Client(ctx context.Context) (*client.Client, error) {
+	return client.New(ctx, d.endpoint.Address, client.WithContextDialer(d.dial))
+}
+
+func (d *Driver) dial(ctx context.Context, _ string) (net.Conn, error) {
+	u, err := url.Parse(d.endpoint.Address)
+	if err != nil {
+		return nil, err
+	}
+	var dialer net.Dialer
+	if u.Scheme == "unix" {
+		return dialer.DialContext(ctx, "unix", u.Path)
+	}
+
+	conn, err := dialer.DialContext(ctx, "tcp", u.Host)
+	if err != nil || d.endpoint.TLS == nil {
+		return conn, err
+	}
+	cfg := d.endpoint.TLS.Clone()
+	if cfg.ServerName == "" {
+		cfg.ServerName = u.Hostname()
+	}
+	// grpc servers require the protocol to be negotiated
+	cfg.NextProtos = []string{"h2"}
+	tlsConn := tls.Client(conn, cfg)
+	if deadline, ok := ctx.Deadline(); ok {
+		tlsConn.SetDeadline(deadline)
+	}
+	if err := tlsConn.Handshake(); err != nil {
+		conn.Close()
+		return nil, errors.Wrap(err, "tls handshake")
+	}
+	tlsConn.SetDeadline(time.Time{})
+	return tlsConn, nil
+}
+
+func (d *Driver) Features() map[driver.Feature]bool {
+	return map[driver.Feature]bool{
+		driver.OCIExporter:    true,
+		driver.DockerExporter: true,
+		driver.CacheExport:    true,
+		driver.MultiPlatform:  true,
+	}
+}
+
+func (d *Driver) IsMobyDriver() bool {
+	return false
+}
+
+func (d *Driver) Config() driver.InitConfig {
+	return d.InitConfig
+}
@@ -0,0 +1,109 @@
+package remote
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/docker/buildx/driver"
+	"github.com/moby/buildkit/client"
+
+	asmdriver "github.com/robertgzr/asm/driver"
+)
+
+func TestValidateEndpoint(t *testing.T) {
+	for _, tc := range []struct {
+		endpoint string
+		valid    bool
+	}{
+		{"tcp://buildkitd:1234", true},
+		{"tcp://127.0.0.1:1234", true},
+		{"unix:///run/buildkit/buildkitd.sock", true},
+		{"tcp://buildkitd", false},
+		{"unix://", false},
+		{"http://buildkitd:1234", false},
+	} {
+		err := (&factory{}).ValidateEndpoint(tc.endpoint)
+		if tc.valid && err != nil {
+			t.Errorf("%s: unexpected error: %s", tc.endpoint, err)
+		}
+		if !tc.valid && err == nil {
+			t.Errorf("%s: expected an error", tc.endpoint)
+		}
+	}
+}
+
+// startBuildkitd runs buildkitd on a unix socket in a temporary directory
+// and returns its address.
+func startBuildkitd(t *testing.T) string {
+	t.Helper()
+	bin, err := exec.LookPath("buildkitd")
+	if err != nil {
+		t.Skip("buildkitd not found")
+	}
+	dir := t.TempDir()
+	addr := "unix://" + filepath.Join(dir, "buildkitd.sock")
+	cmd := exec.Command(bin, "--addr", addr, "--root", filepath.Join(dir, "root"))
+	cmd.Stderr = os.Stderr
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	for {
+		c, err := client.New(ctx, addr)
+		if err == nil {
+			_, err = c.ListWorkers(ctx)
+			c.Close()
+		}
+		if err == nil {
+			return addr
+		}
+		select {
+		case <-ctx.Done():
+			t.Fatalf("buildkitd did not start: %s", err)
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+}
+
+func TestDriver(t *testing.T) {
+	addr := startBuildkitd(t)
+	ctx := context.Background()
+
+	d, err := (&factory{}).NewWithEndpoint(ctx, driver.InitConfig{Name: "asm_buildkit_test"}, asmdriver.Endpoint{Address: addr})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Bootstrap(ctx, func(*client.SolveStatus) {}); err != nil {
+		t.Fatal(err)
+	}
+	info, err := d.Info(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Status != driver.Running {
+		t.Fatalf("expected status %s, got %s", driver.Running, info.Status)
+	}
+
+	c, err := d.Client(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	ws, err := c.ListWorkers(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ws) == 0 {
+		t.Fatal("expected at least one worker")
+	}
+}
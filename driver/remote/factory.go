@@ -0,0 +1,68 @@
+package remote
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+
+	"github.com/docker/buildx/driver"
+	dockerclient "github.com/docker/docker/client"
+
+	asmdriver "github.com/robertgzr/asm/driver"
+)
+
+func init() {
+	driver.Register(&factory{})
+}
+
+type factory struct{}
+
+func (*factory) Name() string {
+	return "remote"
+}
+
+func (*factory) Usage() string {
+	return "remote"
+}
+
+func (*factory) Priority(_ context.Context, _ dockerclient.APIClient) int {
+	// never picked as the default driver, it needs an endpoint
+	return 0
+}
+
+func (f *factory) New(ctx context.Context, cfg driver.InitConfig) (driver.Driver, error) {
+	return nil, fmt.Errorf("the remote driver needs the endpoint of the node")
+}
+
+func (f *factory) NewWithEndpoint(ctx context.Context, cfg driver.InitConfig, ep asmdriver.Endpoint) (driver.Driver, error) {
+	for k := range cfg.DriverOpts {
+		return nil, fmt.Errorf("invalid driver option %s for remote driver", k)
+	}
+	if err := f.ValidateEndpoint(ep.Address); err != nil {
+		return nil, err
+	}
+	return &Driver{factory: f, InitConfig: cfg, endpoint: ep}, nil
+}
+
+// ValidateEndpoint accepts tcp://host:port and unix:///path addresses.
+func (*factory) ValidateEndpoint(endpoint string) error {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return err
+	}
+	switch {
+	case u.Scheme == "tcp" && u.Hostname() != "" && u.Port() != "":
+	case u.Scheme == "unix" && u.Path != "":
+	default:
+		return fmt.Errorf("invalid buildkitd address %q, expected tcp://host:port or unix:///path", endpoint)
+	}
+	return nil
+}
+
+func (*factory) Options() []asmdriver.Option {
+	return []asmdriver.Option{}
+}
+
+func (*factory) AllowsInstances() bool {
+	return true
+}
@@ -2,19 +2,38 @@ package asm
 
 import (
 	"context"
+	"crypto/tls"
+	"fmt"
+	"net/http"
 	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/containerd/containerd/platforms"
+	"github.com/docker/buildx/bake"
 	"github.com/docker/buildx/build"
 	"github.com/docker/buildx/driver"
+	kubernetesdriver "github.com/docker/buildx/driver/kubernetes"
+	"github.com/docker/buildx/util/confutil"
 	dockerclient "github.com/docker/docker/client"
+	"github.com/docker/go-connections/tlsconfig"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 	"golang.org/x/sync/errgroup"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
 
 	"github.com/robertgzr/asm/config"
+	asmdriver "github.com/robertgzr/asm/driver"
 )
 
-func NewDockerClient(host string, opts map[string]string) (dockerclient.APIClient, error) {
+// NewDockerClient connects to the docker daemon at the endpoint of n, giving
+// up after its connect timeout.
+func NewDockerClient(ctx context.Context, n config.Node) (dockerclient.APIClient, error) {
+	host := n.Endpoint
 	if host == "" {
 		return nil, nil
 	}
@@ -23,29 +42,31 @@ func NewDockerClient(host string, opts map[string]string) (dockerclient.APIClien
 		dockerclient.WithHost(host),
 	}
 
+	if strings.HasPrefix(host, "ssh://") {
+		dialer, err := sshDialer(host, n.SSH)
+		if err != nil {
+			return nil, err
+		}
+		// the host is only used for the http requests, like the docker cli
+		// does for its connection helpers
+		clientOpts = []dockerclient.Opt{
+			dockerclient.WithHost("http://docker.example.com"),
+			dockerclient.WithDialContext(dialer),
+		}
+	}
+
 	if version, ok := os.LookupEnv("DOCKER_API_VERSION"); ok {
 		clientOpts = append(clientOpts, dockerclient.WithVersion(version))
 	} else {
 		clientOpts = append(clientOpts, dockerclient.WithAPIVersionNegotiation())
 	}
 
-	var tls bool
-	if opts != nil {
-		caPath, haveCA := opts["ca"]
-		certPath, haveCert := opts["cert"]
-		keyPath, haveKey := opts["key"]
-		if haveCA && haveCert && haveKey {
-			clientOpts = append(clientOpts, dockerclient.WithTLSClientConfig(caPath, certPath, keyPath))
-			// FIXME remove those to not confuse the docker-container driver
-			delete(opts, "ca")
-			delete(opts, "cert")
-			delete(opts, "key")
-		}
-		tls = true
+	if n.TLS != nil {
+		clientOpts = append(clientOpts, withTLS(*n.TLS))
 	}
 
 	logrus.
-		WithField("tls", tls).
+		WithField("tls", n.TLS != nil).
 		WithField("host", host).
 		Debug("connecting to endpoint")
 
@@ -55,12 +76,22 @@ func NewDockerClient(host string, opts map[string]string) (dockerclient.APIClien
 	}
 
 	// test connection and retrieve docker version
-	info, err := c.Info(context.TODO())
+	timeout := time.Duration(n.ConnectTimeout)
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	info, err := c.Info(ctx)
 	if err != nil {
+		c.Close()
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return nil, errors.Errorf("connecting to %s timed out after %s", host, timeout)
+		}
 		return nil, err
 	}
 	logrus.
-		WithField("tls", tls).
+		WithField("tls", n.TLS != nil).
 		WithField("host", host).
 		WithField("docker_version", info.ServerVersion).
 		Debug("connected")
@@ -68,14 +99,196 @@ func NewDockerClient(host string, opts map[string]string) (dockerclient.APIClien
 	return c, nil
 }
 
-// func NewKubernetesClient(context string) (driver.KubeClientConfig, error) {
-// 	// FIXME not sure if this is bad
-// 	dockerCli, err := dockercli.NewDockerCli()
-// 	if err != nil {
-// 		panic(err)
-// 	}
-// 	return kubernetes.ConfigFromContext(context, dockerCli.ContextStore())
-// }
+const (
+	// backoff before the first retry, it doubles with every attempt
+	initialBackoff = 500 * time.Millisecond
+	maxBackoff     = 30 * time.Second
+)
+
+// connect creates the docker client of n, retrying failed attempts with an
+// exponential backoff.
+func connect(ctx context.Context, n config.Node) (c dockerclient.APIClient, err error) {
+	err = retry(ctx, n, func() error {
+		c, err = NewDockerClient(ctx, n)
+		return err
+	})
+	return c, err
+}
+
+// retry runs attempt until it succeeds or the retries of n are used up,
+// with an exponential backoff in between.
+func retry(ctx context.Context, n config.Node, attempt func() error) error {
+	retries := 0
+	if n.Retries != nil {
+		retries = *n.Retries
+	}
+	backoff := initialBackoff
+	for i := 0; ; i++ {
+		err := attempt()
+		if err == nil || i >= retries || ctx.Err() != nil {
+			return err
+		}
+		logrus.
+			WithField("name", n.Name).
+			WithField("attempt", i+1).
+			WithError(err).
+			Debugf("connecting failed, retrying in %s", backoff)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+// newEndpointDriver creates the driver of n for a factory that connects to
+// the endpoint itself and checks that buildkitd answers.
+func newEndpointDriver(ctx context.Context, f asmdriver.EndpointFactory, n config.Node, contextPathHash string) (driver.Driver, error) {
+	ep := asmdriver.Endpoint{Address: n.Endpoint}
+	if n.TLS != nil {
+		cfg, err := tlsConfig(*n.TLS)
+		if err != nil {
+			return nil, err
+		}
+		ep.TLS = cfg
+	}
+	d, err := f.NewWithEndpoint(ctx, driver.InitConfig{
+		Name:            "asm_buildkit_" + n.Name,
+		BuildkitFlags:   n.Flags,
+		Files:           n.Files,
+		DriverOpts:      n.DriverOpts,
+		Platforms:       n.Platforms,
+		ContextPathHash: contextPathHash,
+	}, ep)
+	if err != nil {
+		return nil, err
+	}
+
+	logrus.
+		WithField("tls", n.TLS != nil).
+		WithField("host", n.Endpoint).
+		Debug("connecting to endpoint")
+	err = retry(ctx, n, func() error {
+		ctx := ctx
+		if timeout := time.Duration(n.ConnectTimeout); timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+		c, err := d.Client(ctx)
+		if err != nil {
+			return err
+		}
+		defer c.Close()
+		_, err = c.ListWorkers(ctx)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// withTLS configures the transport of the docker client for t, the client
+// switches to https when it finds a tls config.
+func withTLS(t config.TLS) dockerclient.Opt {
+	return func(c *dockerclient.Client) error {
+		cfg, err := tlsConfig(t)
+		if err != nil {
+			return err
+		}
+
+		transport, ok := c.HTTPClient().Transport.(*http.Transport)
+		if !ok {
+			return errors.Errorf("cannot apply tls config to transport: %T", c.HTTPClient().Transport)
+		}
+		transport.TLSClientConfig = cfg
+		return nil
+	}
+}
+
+// tlsConfig loads the certificates of t.
+func tlsConfig(t config.TLS) (*tls.Config, error) {
+	cfg, err := tlsconfig.Client(tlsconfig.Options{
+		CAFile:             t.CA,
+		CertFile:           t.Cert,
+		KeyFile:            t.Key,
+		InsecureSkipVerify: t.SkipVerify(),
+		ExclusiveRootPools: true,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create tls config")
+	}
+	cfg.ServerName = t.ServerName
+	return cfg, nil
+}
+
+// NewKubernetesClient loads the kubeconfig of k like kubectl does, an empty
+// Kubeconfig falls back to $KUBECONFIG and ~/.kube/config.
+func NewKubernetesClient(k config.Kubernetes) driver.KubeClientConfig {
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	rules.ExplicitPath = k.Kubeconfig
+	overrides := &clientcmd.ConfigOverrides{CurrentContext: k.Context}
+	overrides.Context.Namespace = k.Namespace
+	return clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, overrides)
+}
+
+// newKubernetesClientset creates the client used to check that a cluster
+// answers, tests replace it with a fake.
+var newKubernetesClientset = func(cfg *rest.Config) (kubernetes.Interface, error) {
+	return kubernetes.NewForConfig(cfg)
+}
+
+// newKubernetesDriver creates the kubernetes driver of n and checks that the
+// cluster answers.
+func newKubernetesDriver(ctx context.Context, f driver.Factory, n config.Node, contextPathHash string) (driver.Driver, error) {
+	var k config.Kubernetes
+	if n.Kubernetes != nil {
+		k = *n.Kubernetes
+	}
+	kcc := NewKubernetesClient(k)
+	opts := make(map[string]string, len(n.DriverOpts)+1)
+	for key, v := range n.DriverOpts {
+		opts[key] = v
+	}
+	if k.Replicas != 0 {
+		opts["replicas"] = strconv.Itoa(k.Replicas)
+	}
+
+	// the driver derives the deployment name from the buildx prefix
+	d, err := driver.GetDriver(ctx, "buildx_buildkit_"+n.Name, f, nil, nil, kcc, n.Flags, n.Files, opts, n.Platforms, contextPathHash)
+	if err != nil {
+		return nil, err
+	}
+
+	restCfg, err := kcc.ClientConfig()
+	if err != nil {
+		return nil, err
+	}
+	if timeout := time.Duration(n.ConnectTimeout); timeout > 0 {
+		restCfg.Timeout = timeout
+	}
+	clientset, err := newKubernetesClientset(restCfg)
+	if err != nil {
+		return nil, err
+	}
+	logrus.
+		WithField("host", restCfg.Host).
+		WithField("context", k.Context).
+		Debug("connecting to kubernetes")
+	err = retry(ctx, n, func() error {
+		_, err := clientset.Discovery().ServerVersion()
+		return err
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to connect to kubernetes")
+	}
+	return d, nil
+}
 
 // TODO how can we make this less docker-dependant
 func DriversForNodeGroup(ctx context.Context, ng *config.NodeGroup, contextPathHash string) ([]build.DriverInfo, error) {
@@ -89,7 +302,7 @@ func DriversForNodeGroup(ctx context.Context, ng *config.NodeGroup, contextPathH
 		if !ok {
 			f := driver.GetFactory(n.Driver, false)
 			if f == nil {
-				return nil, errors.Errorf("failed to find driver %q", f)
+				return nil, errors.Errorf("failed to find driver %q", n.Driver)
 			}
 			factories[n.Driver] = f
 		}
@@ -107,26 +320,41 @@ func DriversForNodeGroup(ctx context.Context, ng *config.NodeGroup, contextPathH
 						logrus.
 							WithField("driver", n.Driver).
 							WithField("name", n.Name).
-							Error(di.Err)
+							Debug(di.Err)
 					}
 					dis[i] = di
 				}()
 
-				dockerapi, err := NewDockerClient(n.Endpoint, n.DriverOpts)
+				if n.BuildkitConfig != "" {
+					files, err := confutil.LoadConfigFiles(n.BuildkitConfig)
+					if err != nil {
+						di.Err = err
+						return nil
+					}
+					for k, v := range n.Files {
+						files[k] = v
+					}
+					n.Files = files
+				}
+
+				if ef, ok := factories[n.Driver].(asmdriver.EndpointFactory); ok {
+					di.Driver, di.Err = newEndpointDriver(ctx, ef, n, contextPathHash)
+					return nil
+				}
+
+				if n.Driver == kubernetesdriver.DriverName {
+					di.Driver, di.Err = newKubernetesDriver(ctx, factories[n.Driver], n, contextPathHash)
+					return nil
+				}
+
+				dockerapi, err := connect(ctx, n)
 				if err != nil {
 					di.Err = err
 					return nil
 				}
 
-				// var kcc driver.KubeClientConfig
-				// kcc, err = NewKubernetesClient(n.Endpoint)
-				// if err != nil {
-				// 	return err
-				// }
-
 				d, err := driver.GetDriver(ctx, "asm_buildkit_"+n.Name, factories[n.Driver], dockerapi, nil, nil, n.Flags, n.Files, n.DriverOpts, n.Platforms, contextPathHash)
 				if err != nil {
-					logrus.WithField("driver", n.Name).Error(err)
 					di.Err = err
 					return nil
 				}
@@ -142,3 +370,89 @@ func DriversForNodeGroup(ctx context.Context, ng *config.NodeGroup, contextPathH
 
 	return dis, nil
 }
+
+// NodesRequired is the number of nodes that have to connect for a build to
+// start.
+type NodesRequired int
+
+// AllNodes requires every node to connect.
+const AllNodes NodesRequired = -1
+
+// ParseNodesRequired parses "all", "any" or a number of nodes.
+func ParseNodesRequired(s string) (NodesRequired, error) {
+	switch s {
+	case "all":
+		return AllNodes, nil
+	case "any":
+		return 1, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 1 {
+		return 0, errors.Errorf("invalid number of required nodes %q, expected all, any or a positive number", s)
+	}
+	return NodesRequired(n), nil
+}
+
+// ConnectedDrivers drops the drivers that failed to connect and checks that
+// enough of them remain.
+func ConnectedDrivers(dis []build.DriverInfo, required NodesRequired) ([]build.DriverInfo, error) {
+	connected := make([]build.DriverInfo, 0, len(dis))
+	for _, di := range dis {
+		if di.Err == nil && di.Driver != nil {
+			connected = append(connected, di)
+		}
+	}
+	failed := len(dis) - len(connected)
+	switch {
+	case required == AllNodes && failed > 0:
+		return nil, errors.Errorf("%d of %d nodes failed to connect, all are required", failed, len(dis))
+	case required != AllNodes && len(connected) < int(required):
+		return nil, errors.Errorf("%d of %d nodes connected, %d required", len(connected), len(dis), required)
+	}
+	return connected, nil
+}
+
+// CheckPlatforms reports the platforms requested by targets that were only
+// declared by nodes which failed to connect. Without declared platforms a
+// node is assumed to build any of them.
+func CheckPlatforms(dis []build.DriverInfo, targets map[string]*bake.Target) error {
+	connected := make(map[string]bool)
+	failed := make(map[string][]string)
+	for _, di := range dis {
+		if di.Err == nil && di.Driver != nil && len(di.Platform) == 0 {
+			return nil
+		}
+		for _, p := range di.Platform {
+			k := platforms.Format(platforms.Normalize(p))
+			if di.Err == nil && di.Driver != nil {
+				connected[k] = true
+			} else {
+				failed[k] = append(failed[k], di.Name)
+			}
+		}
+	}
+
+	names := make([]string, 0, len(targets))
+	for name := range targets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var msgs []string
+	for _, name := range names {
+		for _, s := range targets[name].Platforms {
+			p, err := platforms.Parse(s)
+			if err != nil {
+				return err
+			}
+			k := platforms.Format(platforms.Normalize(p))
+			if !connected[k] && len(failed[k]) != 0 {
+				msgs = append(msgs, fmt.Sprintf("target %s: %s is only built by failed nodes (%s)", name, k, strings.Join(failed[k], ", ")))
+			}
+		}
+	}
+	if len(msgs) != 0 {
+		return errors.New(strings.Join(msgs, "\n"))
+	}
+	return nil
+}
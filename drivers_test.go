@@ -0,0 +1,405 @@
+package asm
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/version"
+	"k8s.io/client-go/discovery"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/rest"
+
+	"github.com/robertgzr/asm/config"
+)
+
+// testCA issues certificates for the tls tests.
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "asm test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCA{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// issue returns a certificate and its key in PEM, valid for 127.0.0.1 and
+// localhost.
+func (ca *testCA) issue(t *testing.T, usage x509.ExtKeyUsage) (certPEM, keyPEM []byte) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kb, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: kb})
+}
+
+func writeFile(t *testing.T, dir, name string, b []byte) string {
+	t.Helper()
+	fn := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(fn, b, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return fn
+}
+
+// newDockerTLSServer starts a stand-in for a docker daemon that terminates
+// tls with a certificate issued by ca, requiring a client certificate
+// issued by clientCA if it is set.
+func newDockerTLSServer(t *testing.T, ca, clientCA *testCA) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Api-Version", "1.41")
+		switch {
+		case r.URL.Path == "/_ping":
+			w.Write([]byte("OK"))
+		case strings.HasSuffix(r.URL.Path, "/info"):
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"ServerVersion":"20.10.0-test"}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	certPEM, keyPEM := ca.issue(t, x509.ExtKeyUsageServerAuth)
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.TLS = &tls.Config{Certificates: []tls.Certificate{cert}}
+	// failed handshakes are expected
+	srv.Config.ErrorLog = log.New(ioutil.Discard, "", 0)
+	if clientCA != nil {
+		pool := x509.NewCertPool()
+		pool.AddCert(clientCA.cert)
+		srv.TLS.ClientCAs = pool
+		srv.TLS.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestNewDockerClientTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t)
+	untrusted := newTestCA(t)
+	caFile := writeFile(t, dir, "ca.pem", ca.pem)
+	clientCert, clientKey := ca.issue(t, x509.ExtKeyUsageClientAuth)
+	certFile := writeFile(t, dir, "cert.pem", clientCert)
+	keyFile := writeFile(t, dir, "key.pem", clientKey)
+	noVerify := false
+
+	for _, tc := range []struct {
+		name     string
+		serverCA *testCA
+		clientCA *testCA
+		tls      config.TLS
+		err      string
+	}{
+		{
+			name:     "ca only",
+			serverCA: ca,
+			tls:      config.TLS{CA: caFile},
+		},
+		{
+			name:     "client certificate",
+			serverCA: ca,
+			clientCA: ca,
+			tls:      config.TLS{CA: caFile, Cert: certFile, Key: keyFile},
+		},
+		{
+			name:     "missing client certificate",
+			serverCA: ca,
+			clientCA: ca,
+			tls:      config.TLS{CA: caFile},
+			err:      "certificate",
+		},
+		{
+			name:     "untrusted without verification",
+			serverCA: untrusted,
+			tls:      config.TLS{Verify: &noVerify},
+		},
+		{
+			name:     "untrusted",
+			serverCA: untrusted,
+			tls:      config.TLS{CA: caFile},
+			err:      "certificate",
+		},
+		{
+			name:     "server name mismatch",
+			serverCA: ca,
+			tls:      config.TLS{CA: caFile, ServerName: "builder.internal"},
+			err:      "builder.internal",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			srv := newDockerTLSServer(t, tc.serverCA, tc.clientCA)
+			tlsCfg := tc.tls
+			n := config.Node{
+				Name:           "test",
+				Driver:         "docker",
+				Endpoint:       "tcp://" + srv.Listener.Addr().String(),
+				TLS:            &tlsCfg,
+				ConnectTimeout: config.Duration(5 * time.Second),
+			}
+			c, err := NewDockerClient(context.Background(), n)
+			if tc.err != "" {
+				if err == nil {
+					c.Close()
+					t.Fatalf("expected an error containing %q", tc.err)
+				}
+				if !strings.Contains(err.Error(), tc.err) {
+					t.Fatalf("expected an error containing %q, got %v", tc.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			c.Close()
+		})
+	}
+}
+
+const testKubeconfig = `apiVersion: v1
+kind: Config
+clusters:
+- name: staging
+  cluster: {server: "https://staging.example.com:6443"}
+- name: prod
+  cluster: {server: "https://prod.example.com:6443"}
+users:
+- name: u
+  user: {}
+contexts:
+- name: staging
+  context: {cluster: staging, user: u, namespace: builds}
+- name: prod
+  context: {cluster: prod, user: u}
+current-context: prod
+`
+
+// unreachableClientset fails like a cluster that does not answer.
+type unreachableClientset struct {
+	*fake.Clientset
+}
+
+func (c unreachableClientset) Discovery() discovery.DiscoveryInterface {
+	return unreachableDiscovery{c.Clientset.Discovery().(*fakediscovery.FakeDiscovery)}
+}
+
+type unreachableDiscovery struct {
+	*fakediscovery.FakeDiscovery
+}
+
+func (unreachableDiscovery) ServerVersion() (*version.Info, error) {
+	return nil, errors.New("dial tcp: connection refused")
+}
+
+// withKubernetesClientset makes the kubernetes drivers connect to c.
+func withKubernetesClientset(t *testing.T, c kubernetes.Interface) {
+	t.Helper()
+	orig := newKubernetesClientset
+	newKubernetesClientset = func(*rest.Config) (kubernetes.Interface, error) {
+		return c, nil
+	}
+	t.Cleanup(func() { newKubernetesClientset = orig })
+}
+
+func TestNewKubernetesClient(t *testing.T) {
+	kubeconfig := writeFile(t, t.TempDir(), "config", []byte(testKubeconfig))
+
+	for _, tc := range []struct {
+		name      string
+		k         config.Kubernetes
+		host      string
+		namespace string
+	}{
+		{
+			name:      "current context",
+			k:         config.Kubernetes{Kubeconfig: kubeconfig},
+			host:      "https://prod.example.com:6443",
+			namespace: "default",
+		},
+		{
+			name:      "context",
+			k:         config.Kubernetes{Kubeconfig: kubeconfig, Context: "staging"},
+			host:      "https://staging.example.com:6443",
+			namespace: "builds",
+		},
+		{
+			name:      "namespace",
+			k:         config.Kubernetes{Kubeconfig: kubeconfig, Context: "staging", Namespace: "asm"},
+			host:      "https://staging.example.com:6443",
+			namespace: "asm",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			kcc := NewKubernetesClient(tc.k)
+			cfg, err := kcc.ClientConfig()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cfg.Host != tc.host {
+				t.Errorf("expected host %s, got %s", tc.host, cfg.Host)
+			}
+			ns, _, err := kcc.Namespace()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ns != tc.namespace {
+				t.Errorf("expected namespace %s, got %s", tc.namespace, ns)
+			}
+		})
+	}
+}
+
+func TestKubernetesDriverOpts(t *testing.T) {
+	withKubernetesClientset(t, fake.NewSimpleClientset())
+	kubeconfig := writeFile(t, t.TempDir(), "config", []byte(testKubeconfig))
+
+	for _, tc := range []struct {
+		name     string
+		k        *config.Kubernetes
+		opts     map[string]string
+		replicas string
+	}{
+		{
+			name: "no kubernetes block",
+			opts: map[string]string{"image": "moby/buildkit:latest"},
+		},
+		{
+			name:     "replicas",
+			k:        &config.Kubernetes{Kubeconfig: kubeconfig, Replicas: 3},
+			opts:     map[string]string{"image": "moby/buildkit:latest"},
+			replicas: "3",
+		},
+		{
+			name:     "replicas as driver option",
+			k:        &config.Kubernetes{Kubeconfig: kubeconfig},
+			opts:     map[string]string{"replicas": "2"},
+			replicas: "2",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.k == nil {
+				orig, ok := os.LookupEnv("KUBECONFIG")
+				os.Setenv("KUBECONFIG", kubeconfig)
+				t.Cleanup(func() {
+					if ok {
+						os.Setenv("KUBECONFIG", orig)
+					} else {
+						os.Unsetenv("KUBECONFIG")
+					}
+				})
+			}
+			ng := config.NodeGroup{Nodes: []config.Node{{
+				Name:       "cluster",
+				Driver:     "kubernetes",
+				Kubernetes: tc.k,
+				DriverOpts: tc.opts,
+			}}}
+			dis, err := DriversForNodeGroup(context.Background(), &ng, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if dis[0].Err != nil {
+				t.Fatal(dis[0].Err)
+			}
+			opts := dis[0].Driver.Config().DriverOpts
+			if opts["replicas"] != tc.replicas {
+				t.Errorf("expected replicas %q, got %q", tc.replicas, opts["replicas"])
+			}
+			for k, v := range tc.opts {
+				if k != "replicas" && opts[k] != v {
+					t.Errorf("expected driver option %s=%s, got %q", k, v, opts[k])
+				}
+			}
+			if _, ok := ng.Nodes[0].DriverOpts["replicas"]; ok && tc.opts["replicas"] == "" {
+				t.Error("the driver options of the node were changed")
+			}
+		})
+	}
+}
+
+func TestKubernetesUnreachable(t *testing.T) {
+	withKubernetesClientset(t, unreachableClientset{fake.NewSimpleClientset()})
+	kubeconfig := writeFile(t, t.TempDir(), "config", []byte(testKubeconfig))
+
+	ng := config.NodeGroup{Nodes: []config.Node{{
+		Name:       "cluster",
+		Driver:     "kubernetes",
+		Kubernetes: &config.Kubernetes{Kubeconfig: kubeconfig},
+	}}}
+	dis, err := DriversForNodeGroup(context.Background(), &ng, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dis[0].Err == nil {
+		t.Fatal("expected an error for an unreachable cluster")
+	}
+	if !strings.Contains(dis[0].Err.Error(), "connection refused") {
+		t.Errorf("unexpected error: %s", dis[0].Err)
+	}
+}
@@ -13,13 +13,21 @@ require (
 	github.com/urfave/cli/v2 v2.3.0
 	go.opentelemetry.io/otel v1.0.0-RC1
 	golang.org/x/sync v0.0.0-20210220032951-036812b2e83c
+	gopkg.in/yaml.v3 v3.0.0-20210107192922-496545a6307b
 	sigs.k8s.io/yaml v1.2.0
 )
 
 // NOTE: make sure these are in sync with buildx
 require (
 	github.com/docker/buildx v0.7.0
+	github.com/docker/cli v20.10.8+incompatible
+	github.com/docker/go-connections v0.4.0
+	github.com/docker/go-units v0.4.0
+	github.com/hashicorp/hcl/v2 v2.8.2
 	github.com/moby/buildkit v0.9.1-0.20211019185819-8778943ac3da
+	github.com/zclconf/go-cty v1.7.1
+	k8s.io/apimachinery v0.22.1
+	k8s.io/client-go v0.22.1
 // github.com/moby/buildkit v0.9.1
 )
 
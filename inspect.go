@@ -0,0 +1,102 @@
+package asm
+
+import (
+	"context"
+
+	"github.com/docker/buildx/build"
+	"github.com/docker/buildx/driver"
+	"github.com/docker/buildx/driver/bkimage"
+	"github.com/docker/buildx/util/progress"
+	"github.com/moby/buildkit/client"
+	"golang.org/x/sync/errgroup"
+
+	asmdriver "github.com/robertgzr/asm/driver"
+)
+
+// NodeInfo is the live state of a node.
+type NodeInfo struct {
+	Status driver.Status
+	// BuildKit describes the buildkit the node runs: the image for
+	// container drivers or the docker version for the docker driver, the
+	// buildkit API does not report its own version. It is empty if unknown
+	BuildKit string
+	// Workers is only set while the node is running
+	Workers []*client.WorkerInfo
+}
+
+// Inspect returns the status of d and, if it is running, the workers of its
+// buildkit.
+func Inspect(ctx context.Context, d driver.Driver) (*NodeInfo, error) {
+	info, err := d.Info(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ni := &NodeInfo{
+		Status:   info.Status,
+		BuildKit: buildkitVersion(ctx, d),
+	}
+	if info.Status != driver.Running {
+		return ni, nil
+	}
+
+	c, err := d.Client(ctx)
+	if err != nil {
+		return ni, err
+	}
+	defer c.Close()
+	ni.Workers, err = c.ListWorkers(ctx)
+	return ni, err
+}
+
+func buildkitVersion(ctx context.Context, d driver.Driver) string {
+	if d.IsMobyDriver() {
+		api := d.Config().DockerAPI
+		if api == nil {
+			return "docker"
+		}
+		v, err := api.ServerVersion(ctx)
+		if err != nil || v.Version == "" {
+			return "docker"
+		}
+		return "docker " + v.Version
+	}
+	if image := d.Config().DriverOpts["image"]; image != "" {
+		return image
+	}
+	// only drivers taking an image run the default one
+	opts, _ := asmdriver.Options(d.Factory())
+	for _, o := range opts {
+		if o.Match("image") {
+			return bkimage.DefaultImage
+		}
+	}
+	return ""
+}
+
+// Boot starts the drivers of dis that are not running, a driver that fails to
+// start has its error set instead.
+func Boot(ctx context.Context, dis []build.DriverInfo, printer *progress.Printer) error {
+	var toBoot []int
+	for i, di := range dis {
+		if di.Err != nil || di.Driver == nil {
+			continue
+		}
+		toBoot = append(toBoot, i)
+	}
+
+	eg, _ := errgroup.WithContext(ctx)
+	for _, i := range toBoot {
+		func(i int) {
+			eg.Go(func() error {
+				pw := progress.WithPrefix(printer, dis[i].Name, len(toBoot) > 1)
+				c, err := driver.Boot(ctx, ctx, dis[i].Driver, pw)
+				if err != nil {
+					dis[i].Err = err
+					return nil
+				}
+				return c.Close()
+			})
+		}(i)
+	}
+	return eg.Wait()
+}
@@ -0,0 +1,234 @@
+package asm
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/containerd/containerd/platforms"
+	"github.com/docker/buildx/build"
+	"github.com/docker/buildx/driver"
+	"github.com/docker/buildx/util/platformutil"
+	"github.com/docker/buildx/util/progress"
+	"github.com/moby/buildkit/client"
+	specs "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sync/errgroup"
+
+	"github.com/robertgzr/asm/config"
+)
+
+// DetectPlatforms sets the platforms of the drivers whose nodes declare none
+// to the platforms supported by their buildkit workers, including emulated
+// ones. dis holds the drivers of the nodes of ng in the same order, drivers
+// are started if needed and a driver whose platforms cannot be detected has
+// its error set. cache may be nil.
+func DetectPlatforms(ctx context.Context, ng config.NodeGroup, dis []build.DriverInfo, printer progress.Writer, cache *PlatformCache) error {
+	var toDetect []int
+	for i, n := range ng.Nodes {
+		if len(n.Platforms) != 0 || dis[i].Err != nil || dis[i].Driver == nil {
+			continue
+		}
+		if ps, ok := cache.Get(n); ok {
+			logrus.WithField("name", n.Name).Debugf("cached platforms: %s", platformutil.Format(ps))
+			dis[i].Platform = ps
+			continue
+		}
+		toDetect = append(toDetect, i)
+	}
+	if len(toDetect) == 0 {
+		return nil
+	}
+
+	eg, _ := errgroup.WithContext(ctx)
+	for _, i := range toDetect {
+		func(i int) {
+			eg.Go(func() error {
+				n := ng.Nodes[i]
+				pw := progress.WithPrefix(printer, n.Name, len(toDetect) > 1)
+				ps, err := detectPlatforms(ctx, dis[i].Driver, pw)
+				if err != nil {
+					dis[i].Err = errors.Wrap(err, "detecting platforms")
+					return nil
+				}
+				logrus.WithField("name", n.Name).Debugf("detected platforms: %s", platformutil.Format(ps))
+				dis[i].Platform = ps
+				cache.Set(n, ps)
+				return nil
+			})
+		}(i)
+	}
+	if err := eg.Wait(); err != nil {
+		return err
+	}
+	return cache.Save()
+}
+
+func detectPlatforms(ctx context.Context, d driver.Driver, pw progress.Writer) ([]specs.Platform, error) {
+	c, err := driver.Boot(ctx, ctx, d, pw)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+	ws, err := c.ListWorkers(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return workerPlatforms(ws), nil
+}
+
+// workerPlatforms returns the platforms of the workers without duplicates,
+// in the order the workers report them.
+func workerPlatforms(ws []*client.WorkerInfo) []specs.Platform {
+	var out []specs.Platform
+	seen := make(map[string]bool)
+	for _, w := range ws {
+		for _, p := range w.Platforms {
+			p = platforms.Normalize(p)
+			k := platforms.Format(p)
+			if seen[k] {
+				continue
+			}
+			seen[k] = true
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+// PlatformCache keeps detected platforms in a file, so nodes do not have to
+// be started to detect them on every run. A nil cache is empty.
+type PlatformCache struct {
+	fn  string
+	ttl time.Duration
+
+	mu      sync.Mutex
+	entries map[string]platformCacheEntry
+	changed bool
+}
+
+type platformCacheEntry struct {
+	Platforms []string  `json:"platforms"`
+	Detected  time.Time `json:"detected"`
+}
+
+// platformCacheKey identifies n in the cache, nodes of different groups or
+// profiles may share a name. The fields deciding which buildkit the node
+// connects to are hashed, kubernetes nodes have no endpoint.
+func platformCacheKey(n config.Node) string {
+	b, _ := json.Marshal(struct {
+		Endpoint   string             `json:"endpoint"`
+		Kubernetes *config.Kubernetes `json:"kubernetes"`
+		DriverOpts map[string]string  `json:"driverOpts"`
+	}{n.Endpoint, n.Kubernetes, n.DriverOpts})
+	sum := sha256.Sum256(b)
+	return n.Name + "|" + n.Driver + "|" + hex.EncodeToString(sum[:8])
+}
+
+// OpenPlatformCache opens the platform cache in the config dir, entries older
+// than ttl are ignored.
+func OpenPlatformCache(ttl time.Duration) (*PlatformCache, error) {
+	dir, err := config.ConfigDir()
+	if err != nil {
+		return nil, err
+	}
+	c := &PlatformCache{
+		fn:      filepath.Join(dir, "platforms.json"),
+		ttl:     ttl,
+		entries: make(map[string]platformCacheEntry),
+	}
+	b, err := ioutil.ReadFile(c.fn)
+	if errors.Is(err, os.ErrNotExist) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &c.entries); err != nil {
+		// the cache is rebuilt on the next save
+		logrus.WithField("file", c.fn).Debugf("ignoring platform cache: %s", err)
+		c.entries = make(map[string]platformCacheEntry)
+	}
+	return c, nil
+}
+
+// Get returns the cached platforms of n, if they were detected for a node
+// with the same name, driver and connection within the ttl.
+func (c *PlatformCache) Get(n config.Node) ([]specs.Platform, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[platformCacheKey(n)]
+	if !ok || time.Since(e.Detected) > c.ttl {
+		return nil, false
+	}
+	ps, err := platformutil.Parse(e.Platforms)
+	if err != nil {
+		return nil, false
+	}
+	return ps, true
+}
+
+// Set caches the platforms detected for n.
+func (c *PlatformCache) Set(n config.Node, ps []specs.Platform) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[platformCacheKey(n)] = platformCacheEntry{
+		Platforms: platformutil.Format(ps),
+		Detected:  time.Now(),
+	}
+	c.changed = true
+}
+
+// Save writes the cache if it changed, dropping expired entries.
+func (c *PlatformCache) Save() error {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.changed {
+		return nil
+	}
+	for key, e := range c.entries {
+		if time.Since(e.Detected) > c.ttl {
+			delete(c.entries, key)
+		}
+	}
+	b, err := json.MarshalIndent(c.entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	// written to a temporary file first, concurrent runs may read the cache
+	tmp, err := ioutil.TempFile(filepath.Dir(c.fn), ".platforms-*.json")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), c.fn); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	c.changed = false
+	return nil
+}
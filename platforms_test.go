@@ -0,0 +1,102 @@
+package asm
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/docker/buildx/util/platformutil"
+
+	"github.com/robertgzr/asm/config"
+)
+
+func TestPlatformCacheKey(t *testing.T) {
+	kube := func(k config.Kubernetes) config.Node {
+		return config.Node{Name: "k", Driver: "kubernetes", Kubernetes: &k}
+	}
+	base := kube(config.Kubernetes{Kubeconfig: "/kube/config", Context: "prod", Namespace: "buildkit"})
+	if platformCacheKey(base) != platformCacheKey(kube(config.Kubernetes{Kubeconfig: "/kube/config", Context: "prod", Namespace: "buildkit"})) {
+		t.Error("expected equal nodes to share a key")
+	}
+	for name, n := range map[string]config.Node{
+		"name":       {Name: "other", Driver: "kubernetes", Kubernetes: base.Kubernetes},
+		"driver":     {Name: "k", Driver: "docker", Kubernetes: base.Kubernetes},
+		"endpoint":   {Name: "k", Driver: "kubernetes", Endpoint: "tcp://k:1234", Kubernetes: base.Kubernetes},
+		"kubeconfig": kube(config.Kubernetes{Kubeconfig: "/other/config", Context: "prod", Namespace: "buildkit"}),
+		"context":    kube(config.Kubernetes{Kubeconfig: "/kube/config", Context: "staging", Namespace: "buildkit"}),
+		"namespace":  kube(config.Kubernetes{Kubeconfig: "/kube/config", Context: "prod", Namespace: "default"}),
+		"driverOpts": {Name: "k", Driver: "kubernetes", Kubernetes: base.Kubernetes, DriverOpts: map[string]string{"image": "moby/buildkit"}},
+	} {
+		if platformCacheKey(n) == platformCacheKey(base) {
+			t.Errorf("expected a different %s to change the key", name)
+		}
+	}
+}
+
+func TestPlatformCache(t *testing.T) {
+	dir := t.TempDir()
+	old, ok := os.LookupEnv("XDG_CONFIG_HOME")
+	os.Setenv("XDG_CONFIG_HOME", dir)
+	t.Cleanup(func() {
+		if ok {
+			os.Setenv("XDG_CONFIG_HOME", old)
+		} else {
+			os.Unsetenv("XDG_CONFIG_HOME")
+		}
+	})
+
+	var nilCache *PlatformCache
+	if _, ok := nilCache.Get(config.Node{Name: "a"}); ok {
+		t.Error("expected a nil cache to be empty")
+	}
+
+	c, err := OpenPlatformCache(time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := config.Node{Name: "a", Driver: "docker", Endpoint: "tcp://a:2376"}
+	b := config.Node{Name: "b", Driver: "docker", Endpoint: "tcp://b:2376"}
+	ps, err := platformutil.Parse([]string{"linux/amd64", "linux/arm64"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Set(a, ps)
+	c.Set(b, ps)
+	// b was detected too long ago
+	e := c.entries[platformCacheKey(b)]
+	e.Detected = time.Now().Add(-2 * time.Hour)
+	c.entries[platformCacheKey(b)] = e
+
+	if got, ok := c.Get(a); !ok || len(got) != 2 {
+		t.Errorf("expected the platforms of a, got %v", got)
+	}
+	if _, ok := c.Get(b); ok {
+		t.Error("expected the entry of b to be expired")
+	}
+	if err := c.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err = OpenPlatformCache(time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, ok := c.Get(a); !ok || platformutil.Format(got)[1] != "linux/arm64" {
+		t.Errorf("expected the saved platforms of a, got %v", got)
+	}
+	if _, ok := c.entries[platformCacheKey(b)]; ok {
+		t.Error("expected the expired entry of b to be dropped when saving")
+	}
+
+	// a broken cache is ignored
+	if err := os.WriteFile(filepath.Join(dir, "asm", "platforms.json"), []byte("{"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if c, err = OpenPlatformCache(time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := c.Get(a); ok {
+		t.Error("expected a broken cache to be empty")
+	}
+}
@@ -0,0 +1,143 @@
+package asm
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/robertgzr/asm/config"
+	asmdriver "github.com/robertgzr/asm/driver"
+)
+
+// sshArgs returns the arguments of the ssh command tunneling the docker API
+// of the ssh:// endpoint.
+func sshArgs(endpoint string, s *config.SSH) ([]string, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "ssh" || u.Hostname() == "" {
+		return nil, errors.Errorf("invalid ssh endpoint %q, expected ssh://[user@]host[:port]", endpoint)
+	}
+	if s == nil {
+		s = &config.SSH{}
+	}
+
+	// never prompt, stdin carries the docker API
+	args := []string{"-o", "BatchMode=yes"}
+	if u.User != nil {
+		args = append(args, "-l", u.User.Username())
+	}
+	if port := u.Port(); port != "" {
+		args = append(args, "-p", port)
+	} else if s.Port != 0 {
+		args = append(args, "-p", strconv.Itoa(s.Port))
+	}
+	if s.IdentityFile != "" {
+		args = append(args, "-i", s.IdentityFile, "-o", "IdentitiesOnly=yes")
+	}
+	if s.KnownHosts != "" {
+		args = append(args, "-o", "UserKnownHostsFile="+s.KnownHosts)
+	}
+	if s.HostKeyCheck != "" {
+		args = append(args, "-o", "StrictHostKeyChecking="+s.HostKeyCheck)
+	}
+	return append(args, "--", u.Hostname(), "docker", "system", "dial-stdio"), nil
+}
+
+// sshDialer returns a dialer connecting to the docker daemon of the ssh://
+// endpoint through `docker system dial-stdio`, every connection runs its
+// own ssh process.
+func sshDialer(endpoint string, s *config.SSH) (func(ctx context.Context, network, addr string) (net.Conn, error), error) {
+	args, err := sshArgs(endpoint, s)
+	if err != nil {
+		return nil, err
+	}
+	return func(ctx context.Context, _, _ string) (net.Conn, error) {
+		logrus.WithField("args", args).Debug("starting ssh")
+
+		// the pipes are created here since cmd.Wait would close the ends
+		// returned by StdinPipe and StdoutPipe under a running Read
+		inr, inw, err := os.Pipe()
+		if err != nil {
+			return nil, err
+		}
+		outr, outw, err := os.Pipe()
+		if err != nil {
+			inr.Close()
+			inw.Close()
+			return nil, err
+		}
+		cmd := exec.Command("ssh", args...)
+		stderr := &lockedBuffer{}
+		cmd.Stdin, cmd.Stdout, cmd.Stderr = inr, outw, stderr
+		err = cmd.Start()
+		inr.Close()
+		outw.Close()
+		if err != nil {
+			inw.Close()
+			outr.Close()
+			return nil, errors.Wrap(err, "starting ssh")
+		}
+		go func() {
+			if err := cmd.Wait(); err != nil {
+				logrus.WithField("endpoint", endpoint).Debugf("ssh exited: %s: %s", err, stderr)
+			}
+		}()
+
+		conn, err := asmdriver.NewStdioConn(ctx, inw, outr)
+		if err != nil {
+			cmd.Process.Kill()
+			return nil, err
+		}
+		return &sshConn{Conn: conn, stderr: stderr}, nil
+	}, nil
+}
+
+// sshConn reports what ssh printed when the connection ends unexpectedly,
+// instead of a bare EOF.
+type sshConn struct {
+	net.Conn
+	stderr *lockedBuffer
+}
+
+func (c *sshConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if err == io.EOF {
+		if msg := strings.TrimSpace(c.stderr.String()); msg != "" {
+			if !strings.HasPrefix(msg, "ssh:") {
+				msg = "ssh: " + msg
+			}
+			return n, errors.New(msg)
+		}
+	}
+	return n, err
+}
+
+// lockedBuffer is written by the ssh process while connections read it.
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}